@@ -0,0 +1,170 @@
+package webfinger
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/filters"
+)
+
+const WebFingerBatchPath = "/webfinger/batch"
+
+const (
+	// maxBatchResources is the maximum number of resources accepted in a batch request.
+	maxBatchResources = 5000
+	// batchWorkers is the number of resources from a batch that get resolved concurrently.
+	batchWorkers = 8
+)
+
+// BatchRequest is the body of the requests to the batch WebFinger end-point.
+type BatchRequest struct {
+	Resources []string `json:"resources"`
+}
+
+// BatchResult is the outcome of resolving one of the resources of a batch request,
+// it contains either the JRD document, or the error.
+type BatchResult struct {
+	JRD    *node  `json:"jrd,omitempty"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// actorsSnapshot is a Store that serves the actors collection of the root actor from memory,
+// so that the resources of a batch don't load it again for each of them.
+type actorsSnapshot struct {
+	Store
+	iri    vocab.IRI
+	actors vocab.ItemCollection
+}
+
+func (s actorsSnapshot) Load(iri vocab.IRI, ff ...filters.Check) (vocab.Item, error) {
+	if len(ff) > 0 || !iri.Equals(s.iri, false) {
+		return s.Store.Load(iri, ff...)
+	}
+	// NOTE(marius): the callers filter the collection they receive, so each of them gets its own copy
+	return slices.Clone(s.actors), nil
+}
+
+// withActorsSnapshot returns a copy of the storage, that serves its actors collection from memory.
+func withActorsSnapshot(storage Storage) (Storage, error) {
+	iri := actors.IRI(storage.Root)
+	all, err := storage.Load(iri)
+	if err != nil {
+		return storage, err
+	}
+	if !vocab.IsCollection(all) {
+		return storage, nil
+	}
+	snapshot := actorsSnapshot{Store: storage.Store, iri: iri}
+	err = vocab.OnCollectionIntf(all, func(col vocab.CollectionInterface) error {
+		snapshot.actors = col.Collection()
+		return nil
+	})
+	if err != nil {
+		return storage, err
+	}
+	storage.Store = snapshot
+	return storage, nil
+}
+
+// resolveBatch resolves the resources concurrently, and returns the results indexed by the resource.
+func resolveBatch(storage Storage, host string, resources []string) map[string]BatchResult {
+	results := make(map[string]BatchResult, len(resources))
+	m := sync.Mutex{}
+
+	queue := make(chan string)
+	wg := sync.WaitGroup{}
+	for range min(batchWorkers, len(resources)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for res := range queue {
+				r := BatchResult{Status: http.StatusOK}
+				wf, err := resolveResource(storage, host, res, url.Values{})
+				if err != nil {
+					r.Status = errors.HttpStatus(err)
+					r.Error = err.Error()
+				} else {
+					r.JRD = wf
+				}
+				m.Lock()
+				results[res] = r
+				m.Unlock()
+			}
+		}()
+	}
+	for _, res := range resources {
+		queue <- res
+	}
+	close(queue)
+	wg.Wait()
+	return results
+}
+
+// batchAuthorized returns true if the request has one of the bearer tokens configured for the batch end-point.
+func batchAuthorized(r *http.Request, tokens []string) bool {
+	tok, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || tok == "" {
+		return false
+	}
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(tok), []byte(t)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleWebFingerBatch serves the POST requests for resolving multiple WebFinger resources at once.
+// The end-point is enabled only for the storage backends that have batch tokens configured, and as
+// the holders of the tokens are trusted, the resources are resolved with the visibility of the root actor.
+func (h handler) HandleWebFingerBatch(w http.ResponseWriter, r *http.Request) {
+	storage, err := h.findMatchingStorage(baseURL(r)...)
+	if err != nil {
+		handleErr(h.l)(r, err).ServeHTTP(w, r)
+		return
+	}
+	tokens := storage.Options.WebFinger.BatchTokens
+	if len(tokens) == 0 {
+		handleErr(h.l)(r, errors.NotFoundf("batch WebFinger is not enabled")).ServeHTTP(w, r)
+		return
+	}
+	if !batchAuthorized(r, tokens) {
+		handleErr(h.l)(r, errors.Unauthorizedf("invalid batch WebFinger credentials")).ServeHTTP(w, r)
+		return
+	}
+
+	req := BatchRequest{}
+	if err = json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		handleErr(h.l)(r, errors.NewBadRequest(err, "invalid batch request")).ServeHTTP(w, r)
+		return
+	}
+	if len(req.Resources) > maxBatchResources {
+		handleErr(h.l)(r, errors.BadRequestf("too many resources %d, maximum is %d", len(req.Resources), maxBatchResources)).ServeHTTP(w, r)
+		return
+	}
+	resources := make([]string, 0, len(req.Resources))
+	seen := make(map[string]struct{}, len(req.Resources))
+	for _, res := range req.Resources {
+		if _, ok := seen[res]; !ok {
+			seen[res] = struct{}{}
+			resources = append(resources, res)
+		}
+	}
+
+	if storage, err = withActorsSnapshot(storage); err != nil {
+		h.l.Warnf("unable to load the actors snapshot: %s", err)
+	}
+	dat, _ := json.Marshal(resolveBatch(storage, r.Host, resources))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(dat)
+	h.l.Debugf("%s %s%s %d %s", r.Method, r.Host, r.RequestURI, http.StatusOK, http.StatusText(http.StatusOK))
+}
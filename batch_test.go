@@ -0,0 +1,115 @@
+package webfinger
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/filters"
+)
+
+func TestBatchAuthorized(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{header: "", want: false},
+		{header: "Bearer ", want: false},
+		{header: "Bearer wrong", want: false},
+		{header: "Basic s3cr3t", want: false},
+		{header: "Bearer s3cr3t", want: true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, WebFingerBatchPath, nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		if got := batchAuthorized(r, []string{"s3cr3t"}); got != tt.want {
+			t.Errorf("batchAuthorized(%q) = %t, want %t", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestWithActorsSnapshot(t *testing.T) {
+	root := itemFromJSON(t, `{"id":"https://example.com","type":"Application"}`)
+	db := newMockStore(root, itemFromJSON(t, `{"id":"https://example.com/actors/jdoe","type":"Person"}`))
+	storage, err := withActorsSnapshot(Storage{Store: db, Root: vocab.Actor{ID: "https://example.com"}})
+	if err != nil {
+		t.Fatalf("withActorsSnapshot() error: %s", err)
+	}
+	iri := actors.IRI(storage.Root)
+	for range 3 {
+		col, _ := storage.Load(iri)
+		if !vocab.IsCollection(col) || len(col.(vocab.ItemCollection)) != 1 {
+			t.Errorf("Invalid actors collection %v", col)
+		}
+	}
+	if loads := db.loadsOf(iri); loads != 1 {
+		t.Errorf("The actors collection was loaded %d times, expected %d", loads, 1)
+	}
+	_, _ = storage.Load(iri, filters.SameID("https://example.com/actors/jdoe"))
+	_, _ = storage.Load("https://example.com/objects/1")
+	if loads := db.loadsOf(iri) + db.loadsOf("https://example.com/objects/1"); loads != 3 {
+		t.Errorf("The filtered and other loads should reach the store, got %d loads, expected %d", loads, 3)
+	}
+}
+
+func TestResolveBatch(t *testing.T) {
+	root := itemFromJSON(t, `{"id":"https://example.com","type":"Application","preferredUsername":"example.com"}`)
+	users := []string{"jdoe", "alice", "bob", "carol", "dave", "erin", "frank", "grace", "heidi", "ivan"}
+	items := make([]vocab.Item, 0, len(users))
+	resources := []string{"acct:example.com@example.com", "https://example.com", "acct:", "acct:mallory@example.com"}
+	for _, u := range users {
+		items = append(items, itemFromJSON(t, `{
+			"id": "https://example.com/actors/`+u+`",
+			"type": "Person",
+			"preferredUsername": "`+u+`",
+			"to": ["https://www.w3.org/ns/activitystreams#Public"]
+		}`))
+		resources = append(resources, "acct:"+u+"@example.com", "https://example.com/actors/"+u)
+	}
+	db := newMockStore(root, items...)
+	self, _ := vocab.ToActor(root)
+	storage, err := withActorsSnapshot(Storage{Store: db, Root: *self})
+	if err != nil {
+		t.Fatalf("withActorsSnapshot() error: %s", err)
+	}
+
+	results := resolveBatch(storage, "example.com", resources)
+	if len(results) != len(resources) {
+		t.Fatalf("Invalid number of results %d, expected %d", len(results), len(resources))
+	}
+	for _, res := range resources[:2] {
+		r := results[res]
+		if r.Status != http.StatusOK || r.JRD == nil || r.Error != "" {
+			t.Errorf("Invalid result for %s: %+v", res, r)
+			continue
+		}
+		if len(r.JRD.Links) == 0 || r.JRD.Links[0].Href != "https://example.com" {
+			t.Errorf("Invalid self link for %s: %+v", res, r.JRD.Links)
+		}
+	}
+	if r := results["acct:"]; r.Status != http.StatusBadRequest || r.JRD != nil || r.Error == "" {
+		t.Errorf("Invalid result for an invalid resource: %+v", r)
+	}
+	if r := results["acct:mallory@example.com"]; r.Status != http.StatusNotFound || r.JRD != nil {
+		t.Errorf("Invalid result for a missing actor: %+v", r)
+	}
+	for _, u := range users {
+		want := "https://example.com/actors/" + u
+		for _, res := range []string{"acct:" + u + "@example.com", want} {
+			r := results[res]
+			if r.Status != http.StatusOK || r.JRD == nil {
+				t.Errorf("Invalid result for %s: %+v", res, r)
+				continue
+			}
+			if r.JRD.Subject != "acct:"+u+"@example.com" || len(r.JRD.Links) == 0 || r.JRD.Links[0].Href != want {
+				t.Errorf("Invalid JRD for %s: %+v", res, r.JRD)
+			}
+		}
+	}
+	if loads := db.loadsOf(actors.IRI(storage.Root)); loads != 1 {
+		t.Errorf("The actors collection was loaded %d times, expected only once for the snapshot", loads)
+	}
+}
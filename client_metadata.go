@@ -0,0 +1,153 @@
+package webfinger
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/filters"
+)
+
+// OAuthClientMetadata is the client metadata document from the OAuth2 Client ID Metadata Document draft,
+// which is served at the URL that the client uses as its client_id.
+//
+// https://datatracker.ietf.org/doc/html/draft-ietf-oauth-client-id-metadata-document-00#name-client-metadata
+type OAuthClientMetadata struct {
+	ClientID                string   `json:"client_id"`
+	ClientName              string   `json:"client_name,omitempty"`
+	ClientURI               string   `json:"client_uri,omitempty"`
+	LogoURI                 string   `json:"logo_uri,omitempty"`
+	RedirectURIs            []string `json:"redirect_uris"`
+	GrantTypes              []string `json:"grant_types"`
+	ResponseTypes           []string `json:"response_types"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method"`
+}
+
+// DefaultClientMetadataPath is the path where we serve the client metadata documents of the Application actors,
+// the path of the actor is appended to it.
+const DefaultClientMetadataPath = "/client-metadata"
+
+// redirectAttachmentNames are the names of the Application actor's attachments that we consider
+// to be holding its OAuth2 redirect URIs.
+var redirectAttachmentNames = []string{"redirect_uri", "redirect_uris", "redirect uri", "redirect uris", "redirect", "callback"}
+
+func isRedirectAttachment(it vocab.Item) bool {
+	name := strings.ToLower(strings.TrimSpace(vocab.NameOf(it)))
+	for _, n := range redirectAttachmentNames {
+		if name == n {
+			return true
+		}
+	}
+	return false
+}
+
+// redirectURIsOf returns the redirect URIs from the actor's redirect attachments: the href of a link,
+// the URLs of an object, or when those are missing, the whitespace separated values of its content.
+func redirectURIsOf(app vocab.Actor) []string {
+	uris := make([]string, 0)
+	for _, att := range itemsOf(app.Attachment) {
+		if vocab.IsNil(att) || !isRedirectAttachment(att) {
+			continue
+		}
+		if att.IsLink() {
+			_ = vocab.OnLink(att, func(l *vocab.Link) error {
+				if l.Href != "" {
+					uris = append(uris, string(l.Href))
+				}
+				return nil
+			})
+			continue
+		}
+		found := false
+		_ = vocab.OnObject(att, func(ob *vocab.Object) error {
+			for _, u := range itemsOf(ob.URL) {
+				if !vocab.IsNil(u) {
+					uris = append(uris, string(u.GetLink()))
+					found = true
+				}
+			}
+			return nil
+		})
+		if !found {
+			uris = append(uris, strings.Fields(stripTags(vocab.ContentOf(att)))...)
+		}
+	}
+	return uris
+}
+
+// ClientMetadataIRI returns the IRI of the client metadata document for the app actor, served at path,
+// which is the client_id that the application uses in the OAuth2 flows.
+//
+// NOTE(marius): the path of the actor is kept as is, including a trailing slash, as HandleClientMetadata
+// needs to load the actor with the exact ID from the client_id.
+func ClientMetadataIRI(app vocab.Actor, path string) string {
+	u, err := app.ID.URL()
+	if err != nil {
+		return ""
+	}
+	u.Path = path + u.Path
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
+
+// ClientMetadataOf returns the client metadata document of the app actor.
+//
+// NOTE(marius): our first party applications don't have client secrets, so they are public clients
+// using the authorization code flow.
+func ClientMetadataOf(app vocab.Actor, clientID string) OAuthClientMetadata {
+	meta := OAuthClientMetadata{
+		ClientID:                clientID,
+		ClientName:              vocab.NameOf(app),
+		LogoURI:                 IconOf(app),
+		RedirectURIs:            redirectURIsOf(app),
+		GrantTypes:              []string{"authorization_code", "refresh_token"},
+		ResponseTypes:           []string{"code"},
+		TokenEndpointAuthMethod: "none",
+	}
+	if meta.ClientName == "" {
+		meta.ClientName = vocab.PreferredNameOf(app)
+	}
+	if !vocab.IsNil(app.URL) {
+		meta.ClientURI = string(app.URL.GetLink())
+	}
+	return meta
+}
+
+// HandleClientMetadata returns the handler that serves the client metadata documents of
+// the Application actors at path.
+func (h handler) HandleClientMetadata(path string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storage, err := h.findMatchingStorage(baseURL(r)...)
+		if err != nil {
+			handleErr(h.l)(r, err).ServeHTTP(w, r)
+			return
+		}
+		if storage, err = h.authorizeRequest(r, storage); err != nil {
+			handleErr(h.l)(r, err).ServeHTTP(w, r)
+			return
+		}
+		maybeApp, err := LoadActor(storage, filters.SameID(issuerIRIFromWellKnownRequest(r, path)))
+		if err != nil {
+			handleErr(h.l)(r, errors.Annotatef(err, "unable to find application")).ServeHTTP(w, r)
+			return
+		}
+		if vocab.IsNil(maybeApp) || !filters.HasType(vocab.ApplicationType).Match(maybeApp) {
+			handleErr(h.l)(r, errors.NotFoundf("application not found")).ServeHTTP(w, r)
+			return
+		}
+		app, err := vocab.ToActor(maybeApp)
+		if err != nil {
+			handleErr(h.l)(r, errors.Annotatef(err, "invalid type for application %T", maybeApp)).ServeHTTP(w, r)
+			return
+		}
+		data, _ := json.Marshal(ClientMetadataOf(*app, ClientMetadataIRI(*app, path)))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(data)
+		h.l.Debugf("%s %s%s %d %s", r.Method, r.Host, r.RequestURI, http.StatusOK, http.StatusText(http.StatusOK))
+	}
+}
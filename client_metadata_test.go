@@ -0,0 +1,101 @@
+package webfinger
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+
+	"git.sr.ht/~mariusor/lw"
+	vocab "github.com/go-ap/activitypub"
+)
+
+func TestClientMetadataOf(t *testing.T) {
+	app := vocab.Actor{
+		ID:   "https://example.com/actors/app/",
+		URL:  vocab.IRI("https://app.example.com"),
+		Icon: vocab.IRI("https://app.example.com/logo.png"),
+	}
+	clientID := ClientMetadataIRI(app, DefaultClientMetadataPath)
+	if want := "https://example.com/client-metadata/actors/app/"; clientID != want {
+		t.Errorf("Invalid client metadata IRI %s, expected %s", clientID, want)
+	}
+	meta := ClientMetadataOf(app, clientID)
+	if meta.ClientID != clientID {
+		t.Errorf("Invalid client_id %s, expected %s", meta.ClientID, clientID)
+	}
+	if meta.ClientURI != "https://app.example.com" || meta.LogoURI != "https://app.example.com/logo.png" {
+		t.Errorf("Invalid client_uri %q or logo_uri %q", meta.ClientURI, meta.LogoURI)
+	}
+	if meta.RedirectURIs == nil {
+		t.Errorf("redirect_uris should be an empty list, not null")
+	}
+	if meta.TokenEndpointAuthMethod != "none" {
+		t.Errorf("Invalid token_endpoint_auth_method %q, expected %q", meta.TokenEndpointAuthMethod, "none")
+	}
+}
+
+func TestRedirectURIsOf(t *testing.T) {
+	app := itemFromJSON(t, `{
+		"id": "https://example.com/actors/app",
+		"type": "Application",
+		"attachment": [
+			{"type": "Link", "name": "redirect_uri", "href": "https://app.example.com/callback"},
+			{"type": "Object", "name": "Redirect URIs", "url": ["https://app.example.com/a", "https://app.example.com/b"]},
+			{"type": "Note", "name": "callback", "content": "<p>https://app.example.com/c https://app.example.com/d</p>"},
+			{"type": "Link", "name": "homepage", "href": "https://app.example.com"}
+		]
+	}`)
+	act, err := vocab.ToActor(app)
+	if err != nil {
+		t.Fatalf("invalid actor: %s", err)
+	}
+	want := []string{
+		"https://app.example.com/callback",
+		"https://app.example.com/a",
+		"https://app.example.com/b",
+		"https://app.example.com/c",
+		"https://app.example.com/d",
+	}
+	if got := redirectURIsOf(*act); !slices.Equal(got, want) {
+		t.Errorf("Invalid redirect URIs %v, expected %v", got, want)
+	}
+}
+
+func TestHandler_HandleClientMetadata(t *testing.T) {
+	root := itemFromJSON(t, `{"id":"https://example.com","type":"Application"}`)
+	app := itemFromJSON(t, `{
+		"id": "https://example.com/actors/app/",
+		"type": "Application",
+		"name": "The app",
+		"to": ["https://www.w3.org/ns/activitystreams#Public"],
+		"attachment": [{"type": "Link", "name": "redirect_uri", "href": "https://app.example.com/callback"}]
+	}`)
+	h := handler{s: []Store{newMockStore(root, app)}, l: lw.Dev(lw.SetLevel(lw.ErrorLevel))}
+
+	act, _ := vocab.ToActor(app)
+	clientID := ClientMetadataIRI(*act, DefaultClientMetadataPath)
+	u, _ := url.Parse(clientID)
+	r := httptest.NewRequest(http.MethodGet, u.Path, nil)
+	r.Host = u.Host
+	w := httptest.NewRecorder()
+	h.HandleClientMetadata(DefaultClientMetadataPath)(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("HandleClientMetadata(%s) status %d, expected %d", clientID, w.Code, http.StatusOK)
+	}
+	meta := OAuthClientMetadata{}
+	if err := json.Unmarshal(w.Body.Bytes(), &meta); err != nil {
+		t.Fatalf("invalid client metadata %s: %s", w.Body.Bytes(), err)
+	}
+	if meta.ClientID != clientID {
+		t.Errorf("Invalid client_id %s, expected the requested %s", meta.ClientID, clientID)
+	}
+	if meta.ClientName != "The app" {
+		t.Errorf("Invalid client_name %q, expected the application actor's name", meta.ClientName)
+	}
+	if len(meta.RedirectURIs) != 1 || meta.RedirectURIs[0] != "https://app.example.com/callback" {
+		t.Errorf("Invalid redirect_uris %v", meta.RedirectURIs)
+	}
+}
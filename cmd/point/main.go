@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"crypto"
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
 	"runtime/debug"
@@ -14,6 +16,7 @@ import (
 	m "git.sr.ht/~mariusor/servermux"
 	w "git.sr.ht/~mariusor/wrapper"
 	"github.com/alecthomas/kong"
+	vocab "github.com/go-ap/activitypub"
 	"github.com/go-ap/errors"
 	"github.com/go-ap/webfinger"
 	"github.com/go-ap/webfinger/internal/config"
@@ -30,6 +33,8 @@ var Point struct {
 	Config   []string `name:"config" help:"Configuration path for .env file" group:"config-options" xor:"config-options"`
 	Storage  []string `name:"storage" help:"Storage DSN strings of form type:///path/to/storage." group:"config-options" xor:"config-options"`
 	Verbose  int      `name:"verbose" short:"v" default:"0" type:"counter" help:"Increase verbosity of the log output" `
+
+	ClientMetadataPath string `name:"client-metadata-path" help:"The path where the OAuth2 client metadata documents of Application actors are served." default:"${default_client_metadata_path}"`
 }
 
 var (
@@ -50,6 +55,8 @@ func main() {
 			"default_env": string(config.DEV),
 			"version":     webfinger.Version,
 			"name":        "point",
+
+			"default_client_metadata_path": webfinger.DefaultClientMetadataPath,
 		},
 	)
 
@@ -123,13 +130,43 @@ func main() {
 		m.Use(c.Handler)
 		m.Get(webfinger.WellKnownOAuthAuthorizationServerPath, h.HandleOAuthAuthorizationServer)
 		m.Get(webfinger.WellKnownOAuthAuthorizationServerPath+"/*", h.HandleOAuthAuthorizationServer)
+		m.Get(webfinger.WellKnownOpenIDConfigurationPath, h.HandleOpenIDConfiguration)
+		m.Get(webfinger.WellKnownOpenIDConfigurationPath+"/*", h.HandleOpenIDConfiguration)
+		m.Get(webfinger.WellKnownJWKSPath, h.HandleJWKS)
+		m.Get(webfinger.WellKnownJWKSPath+"/*", h.HandleJWKS)
+		m.Get(webfinger.WellKnownOAuthProtectedResourcePath, h.HandleOAuthProtectedResource)
+		m.Get(webfinger.WellKnownOAuthProtectedResourcePath+"/*", h.HandleOAuthProtectedResource)
+		m.Get(Point.ClientMetadataPath, h.HandleClientMetadata(Point.ClientMetadataPath))
+		m.Get(Point.ClientMetadataPath+"/*", h.HandleClientMetadata(Point.ClientMetadataPath))
 		m.Get(webfinger.WellKnownWebFingerPath, h.HandleWebFinger)
+		m.Post(webfinger.WebFingerBatchPath, h.HandleWebFingerBatch)
+		m.Get(webfinger.WebFingerSearchPath, h.HandleWebFingerSearch)
 		m.Get(webfinger.WellKnownHostPath, h.HandleHostMeta)
+		m.Get(webfinger.WellKnownDIDPath, h.HandleDIDDocument)
+		m.Get(webfinger.AuthorizeInteractionPath, h.HandleAuthorizeInteraction)
 
 		m.HandleFunc(webfinger.NodeInfoDiscoverPath, h.NodeInfoDiscover)
 		m.HandleFunc(webfinger.NodeInfoPath, h.NodeInfo)
+		m.HandleFunc(webfinger.NodeInfoPath+"/*", h.NodeInfo)
+		m.HandleFunc(webfinger.NodeInfo2Path, h.NodeInfo2)
+
+		m.Get(webfinger.InstanceV1Path, h.HandleInstanceV1)
+		m.Get(webfinger.InstanceV2Path, h.HandleInstanceV2)
+	})
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		// NOTE(marius): OpenID Connect discovery appends the well known path to the issuer's path,
+		// which can't be expressed as a route pattern.
+		if r.Method == http.MethodGet && webfinger.IsOpenIDConfigurationPath(r.URL.Path) {
+			c.Handler(http.HandlerFunc(h.HandleOpenIDConfiguration)).ServeHTTP(w, r)
+			return
+		}
+		// NOTE(marius): the same goes for the did:web documents of actors, which are appended to their paths.
+		if r.Method == http.MethodGet && webfinger.IsDIDDocumentPath(r.URL.Path) {
+			c.Handler(http.HandlerFunc(h.HandleDIDDocument)).ServeHTTP(w, r)
+			return
+		}
+		errors.NotFound.ServeHTTP(w, r)
 	})
-	r.NotFound(errors.NotFound.ServeHTTP)
 
 	setters := []m.SetFn{m.Handler(r)}
 
@@ -253,6 +290,11 @@ func loadStoresFromConfigs(paths []string, env config.Env, l lw.Logger) ([]webfi
 		if opts.Listen != "" && Point.ListenOn == "" {
 			Point.ListenOn = opts.Listen
 		}
+		so, err := storeOptions(opts)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid configuration %s: %w", p, err))
+			continue
+		}
 
 		st := opts.Storage
 		db, err := config.Storage(opts.Storage, opts.Env, l)
@@ -269,7 +311,117 @@ func loadStoresFromConfigs(paths []string, env config.Env, l lw.Logger) ([]webfi
 			errs = append(errs, fmt.Errorf("unable to open storage backend %T [%s]%s", db, st.Type, st.Path))
 			continue
 		}
-		stores = append(stores, fs)
+		stores = append(stores, webfinger.WithOptions(fs, so))
 	}
 	return stores, errors.Join(errs...)
 }
+
+// oauthIssuers returns the per issuer OAuth2 options, loading their signing keys.
+func oauthIssuers(issuers map[string]config.OAuthIssuerOptions) (map[vocab.IRI]webfinger.OAuthOptions, error) {
+	if len(issuers) == 0 {
+		return nil, nil
+	}
+	result := make(map[vocab.IRI]webfinger.OAuthOptions, len(issuers))
+	for iri, io := range issuers {
+		if err := webfinger.ValidGrantTypes(io.GrantTypes); err != nil {
+			return nil, errors.Annotatef(err, "invalid OAuth2 options for issuer %s", iri)
+		}
+		o := webfinger.OAuthOptions{
+			Scopes:                       io.Scopes,
+			GrantTypes:                   io.GrantTypes,
+			TokenEndpointAuthMethods:     io.TokenEndpointAuthMethods,
+			TokenEndpointAuthSigningAlgs: io.TokenEndpointAuthSigningAlgs,
+			CodeChallengeMethods:         io.CodeChallengeMethods,
+			DPoPSigningAlgs:              io.DPoPSigningAlgs,
+			ClientIDMetadataDocument:     io.ClientIDMetadataDocument,
+			RevocationEndpoint:           io.RevocationEndpoint,
+			IntrospectionEndpoint:        io.IntrospectionEndpoint,
+			ServiceDocumentation:         io.ServiceDocumentation,
+		}
+		if io.SigningKeyPath != "" {
+			key, err := webfinger.LoadSigningKey(io.SigningKeyPath)
+			if err != nil {
+				return nil, errors.Annotatef(err, "invalid OAuth2 signing key for issuer %s", iri)
+			}
+			o.SigningKey = key
+		}
+		result[vocab.IRI(iri)] = o
+	}
+	return result, nil
+}
+
+func storeOptions(opts config.Options) (webfinger.Options, error) {
+	var prober *webfinger.AuthorizeProber
+	if opts.OAuth.ProbeURL != "" {
+		prober = webfinger.NewAuthorizeProber(opts.OAuth.ProbeURL, opts.OAuth.ProbeTTL)
+	}
+	if err := webfinger.ValidGrantTypes(opts.OAuth.GrantTypes); err != nil {
+		return webfinger.Options{}, err
+	}
+	var signingKey crypto.Signer
+	if opts.OAuth.SigningKeyPath != "" {
+		key, err := webfinger.LoadSigningKey(opts.OAuth.SigningKeyPath)
+		if err != nil {
+			return webfinger.Options{}, err
+		}
+		signingKey = key
+	}
+	issuers, err := oauthIssuers(opts.OAuth.Issuers)
+	if err != nil {
+		return webfinger.Options{}, err
+	}
+	var searchIndex *webfinger.ActorIndex
+	if opts.WebFinger.Search {
+		searchIndex = webfinger.NewActorIndex(opts.WebFinger.SearchIndexTTL)
+	}
+	return webfinger.Options{
+		OpenRegistrations: opts.OpenRegistrations,
+		InteractionURL:    opts.InteractionURL,
+		WebFinger: webfinger.WebFingerOptions{
+			PublicKeyLinks: opts.WebFinger.PublicKeyLinks,
+			AccountDomain:  opts.WebFinger.AccountDomain,
+			Strict:         opts.WebFinger.Strict,
+			BatchTokens:    opts.WebFinger.BatchTokens,
+			SearchIndex:    searchIndex,
+		},
+		Signatures: webfinger.SignatureOptions{
+			Verify: opts.VerifySignatures,
+		},
+		NodeInfo: webfinger.NodeInfoOptions{
+			SoftwareName:       opts.NodeInfo.SoftwareName,
+			SoftwareVersion:    opts.NodeInfo.SoftwareVersion,
+			SoftwareRepository: opts.NodeInfo.SoftwareRepository,
+			SoftwareHomepage:   opts.NodeInfo.SoftwareHomepage,
+			Private:            opts.NodeInfo.Private,
+			StaffAccounts:      opts.NodeInfo.StaffAccounts,
+			Metadata:           opts.NodeInfo.Metadata,
+			Protocols:          opts.NodeInfo.Protocols,
+			Feeds:              opts.NodeInfo.Feeds,
+			InboundServices:    opts.NodeInfo.InboundServices,
+			OutboundServices:   opts.NodeInfo.OutboundServices,
+		},
+		Instance: webfinger.InstanceOptions{
+			Email:            opts.Instance.Email,
+			ContactAccount:   opts.Instance.ContactAccount,
+			Languages:        opts.Instance.Languages,
+			Rules:            opts.Instance.Rules,
+			ApprovalRequired: opts.Instance.ApprovalRequired,
+		},
+		OAuth: webfinger.OAuthOptions{
+			Scopes:                       opts.OAuth.Scopes,
+			GrantTypes:                   opts.OAuth.GrantTypes,
+			TokenEndpointAuthMethods:     opts.OAuth.TokenEndpointAuthMethods,
+			TokenEndpointAuthSigningAlgs: opts.OAuth.TokenEndpointAuthSigningAlgs,
+			CodeChallengeMethods:         opts.OAuth.CodeChallengeMethods,
+			DPoPSigningAlgs:              opts.OAuth.DPoPSigningAlgs,
+			ClientIDMetadataDocument:     opts.OAuth.ClientIDMetadataDocument,
+			RevocationEndpoint:           opts.OAuth.RevocationEndpoint,
+			IntrospectionEndpoint:        opts.OAuth.IntrospectionEndpoint,
+			ServiceDocumentation:         opts.OAuth.ServiceDocumentation,
+			SignMetadata:                 opts.OAuth.SignMetadata || signingKey != nil,
+			SigningKey:                   signingKey,
+			Issuers:                      issuers,
+			Prober:                       prober,
+		},
+	}, nil
+}
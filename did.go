@@ -0,0 +1,179 @@
+package webfinger
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/filters"
+)
+
+// DIDDocument is the DID document of an actor, resolvable using the did:web method.
+//
+// https://www.w3.org/TR/did-core/#core-properties
+// https://w3c-ccg.github.io/did-method-web/
+type DIDDocument struct {
+	Context            []string                `json:"@context"`
+	ID                 string                  `json:"id"`
+	AlsoKnownAs        []string                `json:"alsoKnownAs,omitempty"`
+	VerificationMethod []DIDVerificationMethod `json:"verificationMethod"`
+	Authentication     []string                `json:"authentication"`
+	AssertionMethod    []string                `json:"assertionMethod"`
+}
+
+// DIDVerificationMethod is a public key of the DID subject, encoded as a Multikey when possible,
+// and as a JsonWebKey otherwise.
+type DIDVerificationMethod struct {
+	ID                 string `json:"id"`
+	Type               string `json:"type"`
+	Controller         string `json:"controller"`
+	PublicKeyMultibase string `json:"publicKeyMultibase,omitempty"`
+	PublicKeyJwk       *JWK   `json:"publicKeyJwk,omitempty"`
+}
+
+const (
+	WellKnownDIDPath = "/.well-known/did.json"
+	didDocumentName  = "did.json"
+	didWebPrefix     = "did:web:"
+)
+
+// IsDIDDocumentPath returns true for the paths of the actors' DID documents, where "did.json" is
+// appended to the actor's path.
+func IsDIDDocumentPath(p string) bool {
+	return p != WellKnownDIDPath && strings.HasSuffix(p, "/"+didDocumentName)
+}
+
+// DIDWebOf returns the did:web identifier for the IRI. An IRI without a path corresponds to
+// the /.well-known/did.json document, and the others to the did.json document under their path.
+func DIDWebOf(iri vocab.IRI) string {
+	u, err := iri.URL()
+	if err != nil {
+		return ""
+	}
+	did := didWebPrefix + strings.ReplaceAll(u.Host, ":", "%3A")
+	for _, seg := range strings.Split(strings.Trim(u.Path, "/"), "/") {
+		if seg != "" {
+			// NOTE(marius): the colons are the separators of the path segments in did:web identifiers
+			did += ":" + strings.ReplaceAll(url.PathEscape(seg), ":", "%3A")
+		}
+	}
+	return did
+}
+
+// IRIFromDIDWeb returns the https IRI that corresponds to the did:web identifier.
+func IRIFromDIDWeb(did string) (vocab.IRI, error) {
+	if !strings.HasPrefix(did, didWebPrefix) {
+		return "", errors.BadRequestf("invalid did:web identifier %s", did)
+	}
+	parts := strings.Split(strings.TrimPrefix(did, didWebPrefix), ":")
+	host, err := url.PathUnescape(parts[0])
+	if err != nil || host == "" {
+		return "", errors.BadRequestf("invalid did:web identifier %s", did)
+	}
+	u := url.URL{Scheme: "https", Host: host}
+	for _, seg := range parts[1:] {
+		seg, err = url.PathUnescape(seg)
+		if err != nil || seg == "" || strings.Contains(seg, "/") {
+			return "", errors.BadRequestf("invalid did:web identifier %s", did)
+		}
+		u.Path += "/" + seg
+	}
+	return vocab.IRI(u.String()), nil
+}
+
+// didVerificationMethodID returns the identifier of the key relative to the DID, we use
+// the fragment of the key IRI when there is one.
+func didVerificationMethodID(did string, key vocab.IRI, i int) string {
+	if u, err := key.URL(); err == nil && u.Fragment != "" {
+		return did + "#" + u.Fragment
+	}
+	return did + "#key-" + strconv.Itoa(i+1)
+}
+
+// DIDDocumentOf returns the DID document of the actor, built from its publicKey and its multikeys.
+func DIDDocumentOf(actor vocab.Actor) DIDDocument {
+	did := DIDWebOf(actor.ID)
+	doc := DIDDocument{
+		Context:            []string{"https://www.w3.org/ns/did/v1", "https://w3id.org/security/multikey/v1"},
+		ID:                 did,
+		AlsoKnownAs:        []string{actor.ID.String()},
+		VerificationMethod: make([]DIDVerificationMethod, 0),
+		Authentication:     make([]string, 0),
+		AssertionMethod:    make([]string, 0),
+	}
+	hasJWK := false
+	for i, k := range KeysOf(actor) {
+		vm := DIDVerificationMethod{
+			ID:         didVerificationMethodID(did, k.ID, i),
+			Type:       "Multikey",
+			Controller: did,
+		}
+		if mb, err := multibaseOf(k.Key); err == nil {
+			vm.PublicKeyMultibase = mb
+		} else {
+			jwk, err := JWKOf("", k.Key)
+			if err != nil {
+				continue
+			}
+			vm.Type = "JsonWebKey"
+			vm.PublicKeyJwk = &jwk
+			hasJWK = true
+		}
+		doc.VerificationMethod = append(doc.VerificationMethod, vm)
+		doc.Authentication = append(doc.Authentication, vm.ID)
+		doc.AssertionMethod = append(doc.AssertionMethod, vm.ID)
+	}
+	if hasJWK {
+		doc.Context = append(doc.Context, "https://w3id.org/security/jwk/v1")
+	}
+	return doc
+}
+
+// loadDIDSubject loads the actor identified by the IRI of a did:web identifier, an IRI without
+// a path corresponds to the root actor.
+func loadDIDSubject(storage Storage, iri vocab.IRI) (*vocab.Actor, error) {
+	if u, err := iri.URL(); err == nil && strings.Trim(u.Path, "/") == "" {
+		if ru, err := storage.Root.ID.URL(); err != nil || ru.Host != u.Host {
+			return nil, errors.NotFoundf("actor not found %s", iri)
+		}
+		return &storage.Root, nil
+	}
+	maybeActor, err := LoadActor(storage, filters.SameID(iri))
+	if err != nil {
+		return nil, errors.NewNotFound(err, "unable to find actor %s", iri)
+	}
+	if vocab.IsNil(maybeActor) {
+		return nil, errors.NotFoundf("actor not found %s", iri)
+	}
+	return vocab.ToActor(maybeActor)
+}
+
+// HandleDIDDocument serves /.well-known/did.json for the root actor and /{actor-path}/did.json for the others.
+func (h handler) HandleDIDDocument(w http.ResponseWriter, r *http.Request) {
+	storage, err := h.findMatchingStorage(baseURL(r)...)
+	if err != nil {
+		handleErr(h.l)(r, err).ServeHTTP(w, r)
+		return
+	}
+	if storage, err = h.authorizeRequest(r, storage); err != nil {
+		handleErr(h.l)(r, err).ServeHTTP(w, r)
+		return
+	}
+	// NOTE(marius): /.well-known/did.json belongs to the root actor and /{actor-path}/did.json to the other ones
+	p := strings.TrimSuffix(strings.TrimSuffix(r.URL.EscapedPath(), WellKnownDIDPath), "/"+didDocumentName)
+	self, err := loadDIDSubject(storage, vocab.IRI("https://"+r.Host+p))
+	if err != nil {
+		handleErr(h.l)(r, err).ServeHTTP(w, r)
+		return
+	}
+	data, _ := json.Marshal(DIDDocumentOf(*self))
+
+	w.Header().Set("Content-Type", "application/did+json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+	h.l.Debugf("%s %s%s %d %s", r.Method, r.Host, r.RequestURI, http.StatusOK, http.StatusText(http.StatusOK))
+}
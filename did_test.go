@@ -0,0 +1,188 @@
+package webfinger
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"git.sr.ht/~mariusor/lw"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+func TestDIDWebOf(t *testing.T) {
+	tests := map[vocab.IRI]string{
+		"https://example.com":                  "did:web:example.com",
+		"https://example.com/":                 "did:web:example.com",
+		"https://example.com:8443/":            "did:web:example.com%3A8443",
+		"https://example.com/actors/jdoe":      "did:web:example.com:actors:jdoe",
+		"https://example.com:8443/actors/jdoe": "did:web:example.com%3A8443:actors:jdoe",
+		"https://example.com/actors/j%20doe":   "did:web:example.com:actors:j%20doe",
+		"https://example.com/actors/j:doe":     "did:web:example.com:actors:j%3Adoe",
+	}
+	for iri, want := range tests {
+		got := DIDWebOf(iri)
+		if got != want {
+			t.Errorf("DIDWebOf(%s) = %s, want %s", iri, got, want)
+		}
+		back, err := IRIFromDIDWeb(got)
+		if err != nil {
+			t.Errorf("IRIFromDIDWeb(%s) returned error %s", got, err)
+		}
+		if DIDWebOf(back) != got {
+			t.Errorf("IRIFromDIDWeb(%s) = %s, which doesn't round trip", got, back)
+		}
+	}
+	escaped := map[string]vocab.IRI{
+		"did:web:example.com%3A8443:actors:jdoe": "https://example.com:8443/actors/jdoe",
+		"did:web:example.com:actors:j%20doe":     "https://example.com/actors/j%20doe",
+		"did:web:example.com:actors:j%3Adoe":     "https://example.com/actors/j:doe",
+		"did:web:example.com:actors:j%40doe":     "https://example.com/actors/j@doe",
+	}
+	for did, want := range escaped {
+		if got, err := IRIFromDIDWeb(did); err != nil || got != want {
+			t.Errorf("IRIFromDIDWeb(%s) = %s, %v, want %s", did, got, err, want)
+		}
+	}
+	for _, invalid := range []string{"did:key:z6Mk", "did:web:", "did:web:example.com::jdoe", "did:web:example.com:a%2Fb", "did:web:example.com:a%zz"} {
+		if _, err := IRIFromDIDWeb(invalid); err == nil {
+			t.Errorf("IRIFromDIDWeb(%s) should return an error", invalid)
+		}
+	}
+}
+
+func TestEncodeBase58(t *testing.T) {
+	for _, data := range [][]byte{{}, {0}, {0, 0, 1}, []byte("hello world"), {0xed, 0x01, 0xff}} {
+		dec, err := decodeBase58(encodeBase58(data))
+		if err != nil {
+			t.Fatalf("decodeBase58() returned error %s", err)
+		}
+		if string(dec) != string(data) {
+			t.Errorf("base58 round trip of %v returned %v", data, dec)
+		}
+	}
+	mb := "z6MkrJVnaZkeFzdQyMZu1cgjg7k1pZZ6pvBQ7XJPt4swbTQ2"
+	pub, _ := publicKeyFromMultibase(mb)
+	if got, _ := multibaseOf(pub); got != mb {
+		t.Errorf("multibaseOf() = %s, want %s", got, mb)
+	}
+}
+
+func TestDIDDocumentOf(t *testing.T) {
+	edPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	t.Run("Multikey", func(t *testing.T) {
+		actor := vocab.Actor{
+			ID: "https://example.com/actors/jdoe",
+			PublicKey: vocab.PublicKey{
+				ID:           "https://example.com/actors/jdoe#main",
+				Owner:        "https://example.com/actors/jdoe",
+				PublicKeyPem: pemEncodePublicKey(t, edPub),
+			},
+		}
+		doc := DIDDocumentOf(actor)
+		if doc.ID != "did:web:example.com:actors:jdoe" {
+			t.Errorf("Invalid id %s", doc.ID)
+		}
+		if len(doc.VerificationMethod) != 1 {
+			t.Fatalf("Invalid number of verification methods %d, expected 1", len(doc.VerificationMethod))
+		}
+		vm := doc.VerificationMethod[0]
+		if vm.ID != doc.ID+"#main" || vm.Type != "Multikey" || vm.Controller != doc.ID {
+			t.Errorf("Invalid verification method %+v", vm)
+		}
+		if pub, err := publicKeyFromMultibase(vm.PublicKeyMultibase); err != nil || !edPub.Equal(pub) {
+			t.Errorf("Invalid publicKeyMultibase %s", vm.PublicKeyMultibase)
+		}
+		if len(doc.AssertionMethod) != 1 || doc.AssertionMethod[0] != vm.ID {
+			t.Errorf("Invalid assertionMethod %v", doc.AssertionMethod)
+		}
+	})
+	t.Run("JsonWebKey", func(t *testing.T) {
+		actor := vocab.Actor{
+			ID:        "https://example.com/",
+			PublicKey: vocab.PublicKey{PublicKeyPem: pemEncodePublicKey(t, &ecKey.PublicKey)},
+		}
+		doc := DIDDocumentOf(actor)
+		if doc.ID != "did:web:example.com" {
+			t.Errorf("Invalid id %s", doc.ID)
+		}
+		if len(doc.VerificationMethod) != 1 || doc.VerificationMethod[0].PublicKeyJwk == nil {
+			t.Fatalf("Invalid verification methods %+v, expected a JsonWebKey", doc.VerificationMethod)
+		}
+		if doc.VerificationMethod[0].ID != "did:web:example.com#key-1" {
+			t.Errorf("Invalid verification method id %s", doc.VerificationMethod[0].ID)
+		}
+		if len(doc.Context) != 3 {
+			t.Errorf("Invalid @context %v, expected the JWK context", doc.Context)
+		}
+	})
+}
+
+func TestHandler_HandleDIDDocument(t *testing.T) {
+	root := itemFromJSON(t, `{"id":"https://example.com","type":"Application"}`)
+	actor := itemFromJSON(t, `{"id":"https://example.com/actors/jdoe","type":"Person","to":["https://www.w3.org/ns/activitystreams#Public"]}`)
+	h := handler{s: []Store{newMockStore(root, actor)}, l: lw.Dev(lw.SetLevel(lw.ErrorLevel))}
+
+	tests := []struct {
+		path   string
+		status int
+		id     string
+	}{
+		{path: WellKnownDIDPath, status: http.StatusOK, id: "did:web:example.com"},
+		{path: "/actors/jdoe/did.json", status: http.StatusOK, id: "did:web:example.com:actors:jdoe"},
+		{path: "/actors/mallory/did.json", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		r.Host = "example.com"
+		w := httptest.NewRecorder()
+		h.HandleDIDDocument(w, r)
+		if w.Code != tt.status {
+			t.Errorf("HandleDIDDocument(%s) status %d, expected %d", tt.path, w.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		doc := DIDDocument{}
+		if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+			t.Fatalf("unable to unmarshal DID document: %s", err)
+		}
+		if doc.ID != tt.id {
+			t.Errorf("HandleDIDDocument(%s) id %s, expected %s", tt.path, doc.ID, tt.id)
+		}
+	}
+}
+
+func TestResolveResource_didWeb(t *testing.T) {
+	root := itemFromJSON(t, `{"id":"https://example.com","type":"Application"}`)
+	actor := itemFromJSON(t, `{"id":"https://example.com/actors/jdoe","type":"Person","to":["https://www.w3.org/ns/activitystreams#Public"]}`)
+	self, _ := vocab.ToActor(root)
+	storage := Storage{Store: newMockStore(root, actor), Root: *self}
+
+	tests := map[string]string{
+		"did:web:example.com":             "https://example.com",
+		"did:web:example.com:actors:jdoe": "https://example.com/actors/jdoe",
+	}
+	for res, want := range tests {
+		wf, err := resolveResource(storage, "example.com", res, nil)
+		if err != nil {
+			t.Fatalf("resolveResource(%s) returned error %s", res, err)
+		}
+		if wf.Subject != res || len(wf.Links) == 0 || wf.Links[0].Href != want {
+			t.Errorf("resolveResource(%s) = %+v, expected the self link %s", res, wf, want)
+		}
+	}
+	for _, res := range []string{"did:web:example.com:actors:mallory", "did:web:example.org"} {
+		if _, err := resolveResource(storage, "example.com", res, nil); !errors.IsNotFound(err) {
+			t.Errorf("resolveResource(%s) returned %v, expected a not found error", res, err)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
 
 	"git.sr.ht/~mariusor/lw"
@@ -27,7 +28,65 @@ type Store interface {
 
 type Storage struct {
 	Store
-	Root vocab.Actor
+	Root    vocab.Actor
+	Options Options
+	// Requester is the IRI of the actor for which we evaluate the visibility of the loaded items,
+	// when empty we use the root actor.
+	Requester vocab.IRI
+}
+
+// authorizedIRI returns the IRI for which the visibility of the items is evaluated.
+func (db Storage) authorizedIRI() vocab.IRI {
+	if db.Requester != "" {
+		return db.Requester
+	}
+	return db.Root.GetLink()
+}
+
+// Options holds the values that can be configured for each of the storage backends.
+type Options struct {
+	// OpenRegistrations overrides the registration status inferred from the root actor.
+	OpenRegistrations *bool
+	// InteractionURL is the client URL where the authorize_interaction requests are redirected,
+	// a "{uri}" placeholder is replaced with the IRI of the resource.
+	InteractionURL string
+	WebFinger      WebFingerOptions
+	NodeInfo       NodeInfoOptions
+	Instance       InstanceOptions
+	OAuth          OAuthOptions
+	Signatures     SignatureOptions
+}
+
+// WebFingerOptions are the per storage values that control the WebFinger responses.
+type WebFingerOptions struct {
+	// PublicKeyLinks enables the links to the actors' public keys.
+	PublicKeyLinks bool
+	// AccountDomain is the domain used in the actors' acct: URIs, when empty we use the host of their IRI.
+	AccountDomain string
+	// Strict disables the normalization of the resources which are not valid URIs, like "alice@host".
+	Strict bool
+	// BatchTokens are the bearer tokens accepted by the batch end-point, when empty the end-point is disabled.
+	BatchTokens []string
+	// SearchIndex is the index of the local actors used by the search end-point, when nil the end-point is disabled.
+	SearchIndex *ActorIndex
+}
+
+type configuredStore struct {
+	Store
+	o Options
+}
+
+// WithOptions associates the Options with the Store, and they will be used when serving
+// requests for its root actor.
+func WithOptions(db Store, o Options) Store {
+	return configuredStore{Store: db, o: o}
+}
+
+func optionsOf(db Store) Options {
+	if c, ok := db.(configuredStore); ok {
+		return c.o
+	}
+	return Options{}
 }
 
 func New(l lw.Logger, db ...Store) handler {
@@ -54,8 +113,7 @@ func FilterID(id string) filters.Check {
 func LoadIRI(db Storage, what vocab.IRI, checkFns ...filters.Check) (vocab.Item, error) {
 	var found vocab.Item
 
-	serviceIRI := db.Root.GetLink()
-	result, err := db.Load(what, append(checkFns, filters.Authorized(serviceIRI))...)
+	result, err := db.Load(what, append(checkFns, filters.Authorized(db.authorizedIRI()))...)
 	if err != nil {
 		return nil, errors.NewNotFound(err, "no actors found in storage")
 	}
@@ -73,30 +131,64 @@ func LoadIRI(db Storage, what vocab.IRI, checkFns ...filters.Check) (vocab.Item,
 }
 
 func LoadActor(db Storage, checkFns ...filters.Check) (vocab.Item, error) {
-	if filters.Any(checkFns...).Match(db.Root) {
+	return LoadActorPreferType(db, nil, checkFns...)
+}
+
+// LoadActorPreferType loads the actor matching the checks, and when more than one actor matches,
+// it prefers the ones having the types, in their order.
+func LoadActorPreferType(db Storage, types vocab.ActivityVocabularyTypes, checkFns ...filters.Check) (vocab.Item, error) {
+	rootMatches := filters.Any(checkFns...).Match(db.Root)
+	if rootMatches && (len(types) == 0 || filters.HasType(types...).Match(db.Root)) {
 		return db.Root, nil
 	}
 
-	serviceIRI := db.Root.GetLink()
 	all, _ := db.Load(actors.IRI(db.Root))
 	if vocab.IsNil(all) {
+		if rootMatches {
+			return db.Root, nil
+		}
 		return nil, errors.NotFoundf("no actors found in storage")
 	}
 
-	checkFns = append(checkFns, filters.Authorized(serviceIRI))
+	checkFns = append(checkFns, filters.Authorized(db.authorizedIRI()))
 	if vocab.IsCollection(all) {
 		all = filters.Checks(checkFns).Run(all)
 		err := vocab.OnCollectionIntf(all, func(col vocab.CollectionInterface) error {
-			all = col.Collection().First()
+			it, preferred := firstOfType(col.Collection(), types)
+			if !preferred && rootMatches {
+				// NOTE(marius): none of the actors have the preferred types, so the root actor takes precedence
+				it = nil
+			}
+			all = it
 			return nil
 		})
 		if err != nil {
 			return nil, err
 		}
 	}
+	if vocab.IsNil(all) {
+		if rootMatches {
+			// NOTE(marius): the root actor matched the checks, but not the preferred types
+			return db.Root, nil
+		}
+		return nil, errors.NotFoundf("no matching actor found in storage")
+	}
 	return vocab.ToActor(all)
 }
 
+// firstOfType returns the first item having one of the types, in their order, and true.
+// When none of them match, it returns the first item of the collection and false.
+func firstOfType(col vocab.ItemCollection, types vocab.ActivityVocabularyTypes) (vocab.Item, bool) {
+	for _, typ := range types {
+		for _, it := range col {
+			if filters.HasType(typ).Match(it) {
+				return it, true
+			}
+		}
+	}
+	return col.First(), false
+}
+
 func handleErr(l lw.Logger) func(r *http.Request, e error) errors.ErrorHandlerFn {
 	return func(r *http.Request, e error) errors.ErrorHandlerFn {
 		defer func(r *http.Request, e error) {
@@ -141,35 +233,62 @@ func (h *handler) findMatchingStorage(hosts ...string) (Storage, error) {
 				continue
 			}
 			if app.ID != "" {
-				return Storage{Root: app, Store: db}, nil
+				return Storage{Root: app, Store: db, Options: optionsOf(db)}, nil
 			}
 		}
 	}
 	return Storage{Root: app, Store: nil}, errStorageNotFound
 }
 
-// HandleWebFinger serves /.well-known/webfinger/
-func (h handler) HandleWebFinger(w http.ResponseWriter, r *http.Request) {
-	storage, err := h.findMatchingStorage(baseURL(r)...)
-	if err != nil {
-		handleErr(h.l)(r, err).ServeHTTP(w, r)
-		return
+// isServerActorResource returns true if the resource identifies the server actor, as described by FEP-d556:
+// either the https://host/ IRI, or the acct:host@host handle, where host is the root actor's host.
+//
+// https://codeberg.org/fediverse/fep/src/branch/main/fep/d556/fep-d556.md
+func isServerActorResource(typ, user, domain, res string, root vocab.Actor) bool {
+	u, err := root.ID.URL()
+	if err != nil || u.Host == "" {
+		return false
 	}
+	switch typ {
+	case "acct":
+		return strings.EqualFold(user, u.Host) && strings.EqualFold(domain, u.Host)
+	case "https", "http":
+		ru, err := url.Parse(res)
+		if err != nil {
+			return false
+		}
+		return strings.EqualFold(ru.Host, u.Host) && strings.Trim(ru.Path, "/") == "" && ru.RawQuery == ""
+	}
+	return false
+}
 
-	res := r.URL.Query().Get("resource")
+// resolveResource builds the JRD document for the WebFinger resource, by loading it from the storage.
+// The host is the one the request was made to, and q holds the optional "type" and "attributedTo" parameters.
+func resolveResource(storage Storage, host, res string, q url.Values) (*node, error) {
 	if res == "" {
-		handleErr(h.l)(r, errors.NotFoundf("resource not found %s", res)).ServeHTTP(w, r)
-		return
+		return nil, errors.NotFoundf("resource not found %s", res)
+	}
+	if !storage.Options.WebFinger.Strict {
+		// NOTE(marius): the normalized value is used as the subject, so the clients learn its proper form
+		res = normalizeResource(res)
 	}
 
 	hosts := make([]string, 0)
-	hosts = append(hosts, r.Host)
+	hosts = append(hosts, host)
 	typ, handle := splitResourceString(res)
+	if strings.HasPrefix(res, didWebPrefix) {
+		typ, handle = "did", res
+	}
 	if typ == "" || handle == "" {
-		handleErr(h.l)(r, errors.BadRequestf("invalid resource %s", res)).ServeHTTP(w, r)
-		return
+		return nil, errors.BadRequestf("invalid resource %s", res)
 	}
+	domain := ""
+	var types vocab.ActivityVocabularyTypes
 	if typ == "acct" {
+		var err error
+		if handle, types, err = actorTypeHint(handle, q); err != nil {
+			return nil, err
+		}
 		if strings.Contains(handle, "@") {
 			nh, hh := func(s string) (string, string) {
 				if ar := strings.Split(s, "@"); len(ar) == 2 {
@@ -179,6 +298,7 @@ func (h handler) HandleWebFinger(w http.ResponseWriter, r *http.Request) {
 			}(handle)
 			hosts = append(hosts, hh)
 			handle = nh
+			domain = hh
 		}
 	}
 
@@ -186,35 +306,78 @@ func (h handler) HandleWebFinger(w http.ResponseWriter, r *http.Request) {
 	subject := res
 
 	var result vocab.Item
-	if typ == "acct" {
-		a, err := LoadActor(storage, FilterName(handle))
+	switch {
+	case isServerActorResource(typ, handle, domain, res, storage.Root):
+		result = storage.Root
+	case typ == "acct":
+		a, err := LoadActorPreferType(storage, types, FilterName(handle))
 		if err != nil {
-			handleErr(h.l)(r, errors.NewNotFound(err, "resource not found %s", res)).ServeHTTP(w, r)
-			return
+			return nil, errors.NewNotFound(err, "resource not found %s", res)
 		}
 		result = a
-	}
-	if typ == "https" {
+	case typ == "did":
+		iri, err := IRIFromDIDWeb(res)
+		if err != nil {
+			return nil, err
+		}
+		a, err := loadDIDSubject(storage, iri)
+		if err != nil {
+			return nil, errors.NewNotFound(err, "resource not found %s", res)
+		}
+		result = a
+	case typ == "https":
 		ob, err := LoadIRI(storage, vocab.IRI(res), filters.Any(FilterURL(res), FilterID(res)))
 		if err != nil {
-			handleErr(h.l)(r, errors.NewNotFound(err, "resource not found %s", res)).ServeHTTP(w, r)
-			return
+			return nil, errors.NewNotFound(err, "resource not found %s", res)
+		}
+		if filters.HasType(ValidActorTypes...).Match(ob) {
+			// NOTE(marius): LoadIRI returns the actors as plain objects, and we need their preferredUsername
+			// for the acct: subject, so we load them again as actors
+			if a, err := LoadActor(storage, filters.SameID(ob.GetLink())); err == nil && !vocab.IsNil(a) {
+				ob = a
+			}
 		}
 		result = ob
 	}
 	if result == nil || vocab.IsNil(result) {
-		handleErr(h.l)(r, errors.NotFoundf("resource not found %s", res)).ServeHTTP(w, r)
-		return
+		return nil, errors.NotFoundf("resource not found %s", res)
+	}
+	isActor := filters.HasType(ValidActorTypes...).Match(result)
+	if typ == "https" && !isActor && wantsAttributedTo(q) {
+		author := attributedToOf(result)
+		if author == "" {
+			return nil, errors.NotFoundf("resource %s has no author", res)
+		}
+		a, err := LoadActor(storage, filters.SameID(author))
+		if err != nil || vocab.IsNil(a) {
+			return nil, errors.NewNotFound(err, "author not found %s", author)
+		}
+		result, isActor = a, true
+	}
+	if typ == "https" && isActor {
+		// NOTE(marius): for reverse lookups we return the canonical acct: URI of the actor as the subject
+		if acct := AccountURI(result, storage.Options.WebFinger.AccountDomain); acct != "" {
+			subject = acct
+		}
 	}
 
 	id := result.GetID()
 	wf.Subject = subject
-	wf.Links = []link{
-		{
-			Rel:  "self",
-			Type: "application/activity+json",
-			Href: id.String(),
-		},
+	self := link{
+		Rel:  "self",
+		Type: "application/activity+json",
+		Href: id.String(),
+	}
+	if typ := result.GetType(); typ != "" && isActor {
+		self.Properties = map[string]string{propertyActorType: string(typ)}
+	}
+	wf.Links = []link{self}
+	if isActor {
+		// NOTE(marius): only actors can be followed, so the remote follow link makes no sense for other objects
+		wf.Links = append(wf.Links, link{
+			Rel:      OStatusSubscribeRel,
+			Template: subscribeTemplate(host),
+		})
 	}
 	_ = vocab.OnObject(result, func(ob *vocab.Object) error {
 		if vocab.IsNil(ob.URL) {
@@ -248,6 +411,43 @@ func (h handler) HandleWebFinger(w http.ResponseWriter, r *http.Request) {
 		wf.Aliases = append(wf.Aliases, id.String())
 		return nil
 	})
+	_ = vocab.OnActor(result, func(act *vocab.Actor) error {
+		if did := DIDWebOf(act.ID); did != "" {
+			wf.Aliases = append(wf.Aliases, did)
+		}
+		if iss, ok := openIDIssuerOf(*act, storage.Root); ok {
+			wf.Links = append(wf.Links, link{Rel: OpenIDIssuerRel, Href: iss.String()})
+		}
+		if storage.Options.WebFinger.PublicKeyLinks {
+			wf.Links = append(wf.Links, publicKeyLinks(*act)...)
+		}
+		return nil
+	})
+	if subject != res && !slices.Contains(wf.Aliases, id.String()) {
+		wf.Aliases = append(wf.Aliases, id.String())
+	}
+
+	return &wf, nil
+}
+
+// HandleWebFinger serves /.well-known/webfinger/
+func (h handler) HandleWebFinger(w http.ResponseWriter, r *http.Request) {
+	storage, err := h.findMatchingStorage(baseURL(r)...)
+	if err != nil {
+		handleErr(h.l)(r, err).ServeHTTP(w, r)
+		return
+	}
+	if storage, err = h.authorizeRequest(r, storage); err != nil {
+		handleErr(h.l)(r, err).ServeHTTP(w, r)
+		return
+	}
+
+	q := r.URL.Query()
+	wf, err := resolveResource(storage, r.Host, q.Get("resource"), q)
+	if err != nil {
+		handleErr(h.l)(r, err).ServeHTTP(w, r)
+		return
+	}
 
 	dat, _ := json.Marshal(wf)
 	w.Header().Set("Content-Type", "application/jrd+json")
@@ -0,0 +1,76 @@
+package webfinger
+
+import (
+	"sync"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/filters"
+)
+
+// mockStore is an in memory Store, which keeps the root actor, the items, and the collection of the
+// actors among them. It counts the loads for each IRI.
+type mockStore struct {
+	mu    sync.Mutex
+	items map[vocab.IRI]vocab.Item
+	loads map[vocab.IRI]int
+}
+
+func newMockStore(root vocab.Item, items ...vocab.Item) *mockStore {
+	m := mockStore{items: make(map[vocab.IRI]vocab.Item), loads: make(map[vocab.IRI]int)}
+	m.items[root.GetLink()] = root
+	all := make(vocab.ItemCollection, 0)
+	for _, it := range items {
+		m.items[it.GetLink()] = it
+		if filters.HasType(ValidActorTypes...).Match(it) {
+			all = append(all, it)
+		}
+	}
+	m.items[actors.IRI(root)] = all
+	return &m
+}
+
+func (m *mockStore) Open() error { return nil }
+func (m *mockStore) Close()      {}
+
+func (m *mockStore) Load(iri vocab.IRI, ff ...filters.Check) (vocab.Item, error) {
+	m.mu.Lock()
+	m.loads[iri]++
+	it, ok := m.items[iri]
+	m.mu.Unlock()
+	if !ok {
+		return nil, errors.NotFoundf("%s not found", iri)
+	}
+	if it = filters.Checks(ff).Run(it); vocab.IsNil(it) {
+		return nil, errors.NotFoundf("%s not found", iri)
+	}
+	return it, nil
+}
+
+func (m *mockStore) loadsOf(iri vocab.IRI) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.loads[iri]
+}
+
+// itemFromJSON decodes the ActivityPub JSON document.
+func itemFromJSON(t *testing.T, raw string) vocab.Item {
+	t.Helper()
+	it, err := vocab.UnmarshalJSON([]byte(raw))
+	if err != nil {
+		t.Fatalf("unable to unmarshal %s: %s", raw, err)
+	}
+	return it
+}
+
+func TestAggRepo_Load(t *testing.T) {
+	root := itemFromJSON(t, `{"id":"https://example.com","type":"Application"}`)
+	private := itemFromJSON(t, `{"id":"https://example.com/objects/private","type":"Note","to":["https://example.com/actors/jdoe"]}`)
+	storage := Storage{Store: newMockStore(root, private), Root: vocab.Actor{ID: "https://example.com"}, Requester: vocab.PublicNS}
+
+	// NOTE(marius): the usage counts don't depend on the requester, so the private items are loaded too
+	if _, err := aggRepo(storage).Load(private.GetLink()); err != nil {
+		t.Errorf("aggRepo.Load(%s) returned error %s, expected the root actor's visibility", private.GetLink(), err)
+	}
+}
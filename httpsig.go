@@ -0,0 +1,594 @@
+package webfinger
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/filters"
+)
+
+// KeyResolver loads the public key identified by the keyId of an HTTP Signature,
+// and returns it together with the IRI of the actor that owns it.
+type KeyResolver interface {
+	ResolveKey(ctx context.Context, keyID vocab.IRI) (crypto.PublicKey, vocab.IRI, error)
+}
+
+// KeyResolverFunc is an adapter that allows using a function as a KeyResolver.
+type KeyResolverFunc func(ctx context.Context, keyID vocab.IRI) (crypto.PublicKey, vocab.IRI, error)
+
+func (f KeyResolverFunc) ResolveKey(ctx context.Context, keyID vocab.IRI) (crypto.PublicKey, vocab.IRI, error) {
+	return f(ctx, keyID)
+}
+
+// SignatureOptions are the per storage values for the verification of the HTTP Signatures of incoming requests.
+type SignatureOptions struct {
+	// Verify enables the verification, and then the visibility of the actors and objects is evaluated
+	// for the actor that signed the request. The requests without a signature can see only public items.
+	Verify bool
+	// Resolver loads the keys of the requesters, when missing we look for the key in the storage
+	// first and fetch it from its origin after.
+	Resolver KeyResolver
+}
+
+// maxSignatureAge is the maximum difference between the Date of a signed request and the current time.
+const maxSignatureAge = 12 * time.Hour
+
+type signatureParams struct {
+	KeyID     vocab.IRI
+	Algorithm string
+	Headers   []string
+	Signature []byte
+}
+
+// parseSignature parses the value of the Signature header, or of the Authorization header
+// with the "Signature" scheme.
+//
+// https://datatracker.ietf.org/doc/html/draft-cavage-http-signatures-12#section-4.1
+func parseSignature(r *http.Request) (*signatureParams, error) {
+	val := r.Header.Get("Signature")
+	if val == "" {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Signature ") {
+			return nil, errors.NotFoundf("missing HTTP signature")
+		}
+		val = strings.TrimPrefix(auth, "Signature ")
+	}
+
+	p := signatureParams{Headers: []string{"date"}}
+	for _, param := range strings.Split(val, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if !ok {
+			continue
+		}
+		v = strings.Trim(v, `"`)
+		switch k {
+		case "keyId":
+			p.KeyID = vocab.IRI(v)
+		case "algorithm":
+			p.Algorithm = strings.ToLower(v)
+		case "headers":
+			p.Headers = strings.Fields(strings.ToLower(v))
+		case "signature":
+			sig, err := base64.StdEncoding.DecodeString(v)
+			if err != nil {
+				return nil, errors.NewBadRequest(err, "invalid HTTP signature encoding")
+			}
+			p.Signature = sig
+		}
+	}
+	if p.KeyID == "" || len(p.Signature) == 0 {
+		return nil, errors.BadRequestf("invalid HTTP signature, missing keyId or signature")
+	}
+	return &p, nil
+}
+
+// signingString builds the string that was signed from the request's headers.
+func signingString(r *http.Request, headers []string) (string, error) {
+	lines := make([]string, 0, len(headers))
+	for _, h := range headers {
+		var v string
+		switch h {
+		case "(request-target)":
+			v = strings.ToLower(r.Method) + " " + r.URL.RequestURI()
+		case "host":
+			v = r.Host
+		default:
+			vals := r.Header.Values(h)
+			if len(vals) == 0 {
+				return "", errors.BadRequestf("missing signed header %s", h)
+			}
+			v = strings.Join(vals, ", ")
+		}
+		lines = append(lines, h+": "+v)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func hasSignedHeader(headers []string, h string) bool {
+	for _, sh := range headers {
+		if sh == h {
+			return true
+		}
+	}
+	return false
+}
+
+// verifySignature checks the signature over data with the public key. The algorithm parameter
+// is deprecated by the draft, so we rely on the type of the key instead, and reject only the
+// algorithms that don't match it.
+func verifySignature(alg string, pub crypto.PublicKey, data, sig []byte) error {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if alg == "rsa-sha512" {
+			sum := sha512.Sum512(data)
+			return rsa.VerifyPKCS1v15(k, crypto.SHA512, sum[:], sig)
+		}
+		if alg != "" && alg != "hs2019" && alg != "rsa-sha256" {
+			return errors.Newf("algorithm %s doesn't match the RSA key", alg)
+		}
+		sum := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, sum[:], sig)
+	case ed25519.PublicKey:
+		if alg != "" && alg != "hs2019" && alg != "ed25519" {
+			return errors.Newf("algorithm %s doesn't match the Ed25519 key", alg)
+		}
+		if !ed25519.Verify(k, data, sig) {
+			return errors.Newf("invalid Ed25519 signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if alg != "" && alg != "hs2019" && alg != "ecdsa-sha256" {
+			return errors.Newf("algorithm %s doesn't match the ECDSA key", alg)
+		}
+		sum := sha256.Sum256(data)
+		if !ecdsa.VerifyASN1(k, sum[:], sig) {
+			return errors.Newf("invalid ECDSA signature")
+		}
+		return nil
+	}
+	return errors.Newf("unsupported public key type %T", pub)
+}
+
+// VerifyHTTPSignature verifies the HTTP Signature of the request, and returns the IRI of the actor
+// that owns the key which signed it.
+//
+// The signature must cover at least the request target, the host and the date headers, and the date
+// must be recent, so it can't be replayed against other resources or at a later time.
+func VerifyHTTPSignature(r *http.Request, resolver KeyResolver) (vocab.IRI, error) {
+	p, err := parseSignature(r)
+	if err != nil {
+		return "", err
+	}
+	for _, h := range []string{"(request-target)", "host", "date"} {
+		if !hasSignedHeader(p.Headers, h) {
+			return "", errors.BadRequestf("HTTP signature doesn't cover the %s header", h)
+		}
+	}
+	date, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil {
+		return "", errors.NewBadRequest(err, "invalid Date header")
+	}
+	if skew := time.Since(date); skew > maxSignatureAge || skew < -maxSignatureAge {
+		return "", errors.BadRequestf("HTTP signature date is outside the accepted interval")
+	}
+	data, err := signingString(r, p.Headers)
+	if err != nil {
+		return "", err
+	}
+	pub, owner, err := resolver.ResolveKey(r.Context(), p.KeyID)
+	if err != nil {
+		return "", errors.Annotatef(err, "unable to load key %s", p.KeyID)
+	}
+	if err = verifySignature(p.Algorithm, pub, []byte(data), p.Signature); err != nil {
+		return "", err
+	}
+	return owner, nil
+}
+
+// keyOwnerIRI returns the IRI of the actor that probably owns the key, by removing the fragment.
+func keyOwnerIRI(keyID vocab.IRI) vocab.IRI {
+	u, err := keyID.URL()
+	if err != nil {
+		return keyID
+	}
+	u.Fragment = ""
+	return vocab.IRI(u.String())
+}
+
+// storageKeyResolver resolves the keys of the actors in the storage.
+func storageKeyResolver(db Storage) KeyResolver {
+	return KeyResolverFunc(func(_ context.Context, keyID vocab.IRI) (crypto.PublicKey, vocab.IRI, error) {
+		it, err := LoadActor(db, filters.SameID(keyOwnerIRI(keyID)))
+		if err != nil {
+			return nil, "", err
+		}
+		act, err := vocab.ToActor(it)
+		if err != nil || act == nil {
+			return nil, "", errors.NotFoundf("actor not found for key %s", keyID)
+		}
+		for _, k := range KeysOf(*act) {
+			if k.ID == keyID {
+				return k.Key, act.ID, nil
+			}
+		}
+		return nil, "", errors.NotFoundf("key %s not found for actor %s", keyID, act.ID)
+	})
+}
+
+// remoteDocument is the subset of the properties of an actor, or of a key document, that we need for
+// loading the public keys.
+type remoteDocument struct {
+	ID               vocab.IRI        `json:"id"`
+	Owner            vocab.IRI        `json:"owner"`
+	Controller       vocab.IRI        `json:"controller"`
+	PublicKeyPem     string           `json:"publicKeyPem"`
+	PublicKey        remoteKeys       `json:"publicKey"`
+	AssertionMethods AssertionMethods `json:"assertionMethod"`
+}
+
+// remoteKeys is the value of the publicKey property, which can be a key, the IRI of a key, or a list of them.
+// The keys referenced only by their IRI have no key material.
+type remoteKeys []remoteDocument
+
+func (k *remoteKeys) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	raw := make([]json.RawMessage, 0)
+	if len(data) > 0 && data[0] == '[' {
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+	} else {
+		raw = append(raw, data)
+	}
+	for _, r := range raw {
+		r = bytes.TrimSpace(r)
+		switch {
+		case len(r) == 0 || bytes.Equal(r, []byte("null")):
+			continue
+		case r[0] == '"':
+			iri := ""
+			if err := json.Unmarshal(r, &iri); err != nil {
+				return err
+			}
+			*k = append(*k, remoteDocument{ID: vocab.IRI(iri)})
+		default:
+			d := remoteDocument{}
+			if err := json.Unmarshal(r, &d); err != nil {
+				return err
+			}
+			*k = append(*k, d)
+		}
+	}
+	return nil
+}
+
+// listsKey returns true if the document is an actor that has the key in its publicKey or assertionMethod.
+func (d remoteDocument) listsKey(keyID vocab.IRI) bool {
+	for _, k := range d.PublicKey {
+		if k.ID == keyID {
+			return true
+		}
+	}
+	for _, k := range d.AssertionMethods {
+		if k.ID == keyID {
+			return true
+		}
+	}
+	return false
+}
+
+// claimedOwner returns the IRI of the actor the document claims to own the key: the document itself
+// when it's an actor listing the key, or the owner of a key document.
+func (d remoteDocument) claimedOwner(keyID vocab.IRI) vocab.IRI {
+	if d.listsKey(keyID) {
+		return d.ID
+	}
+	if d.ID != keyID {
+		return ""
+	}
+	if d.Owner != "" {
+		return d.Owner
+	}
+	return d.Controller
+}
+
+// keyOf returns the public key, as it is listed by the actor document.
+// The keys that the actor references only by their IRI are not found.
+func (d remoteDocument) keyOf(keyID vocab.IRI, now time.Time) (crypto.PublicKey, error) {
+	for _, k := range d.PublicKey {
+		if k.ID == keyID && k.PublicKeyPem != "" && (k.Owner == "" || k.Owner.Equals(d.ID, false)) {
+			return publicKeyFromPEM(k.PublicKeyPem)
+		}
+	}
+	for _, k := range d.AssertionMethods.Keys(d.ID, now) {
+		if k.ID == keyID {
+			return k.Key, nil
+		}
+	}
+	return nil, errors.NotFoundf("key %s is not listed by actor %s", keyID, d.ID)
+}
+
+const (
+	// DefaultKeyCacheTTL is the duration for which the keys fetched from their origin are cached.
+	DefaultKeyCacheTTL = 10 * time.Minute
+	// DefaultKeyFetchTimeout is the maximum duration of a request for a remote key or actor.
+	DefaultKeyFetchTimeout = 10 * time.Second
+
+	maxKeyDocumentSize = 1 << 20
+	maxCachedKeys      = 4096
+)
+
+// sharedAddressSpace is the RFC6598 carrier grade NAT range, which is not covered by netip.Addr.IsPrivate.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicAddressOnly refuses the connections to the loopback, private and link local addresses, so the
+// keyId of a signature can't be used for making requests to the internal network. It's called after
+// the host name has been resolved, so it covers the names resolving to internal addresses.
+func publicAddressOnly(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip) {
+		return errors.Forbiddenf("refusing to connect to non public address %s", ip)
+	}
+	return nil
+}
+
+// sameOrigin returns true if the IRIs have the same scheme, host and port.
+func sameOrigin(i1, i2 vocab.IRI) bool {
+	u1, err := i1.URL()
+	if err != nil {
+		return false
+	}
+	u2, err := i2.URL()
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u1.Scheme, u2.Scheme) && strings.EqualFold(u1.Host, u2.Host)
+}
+
+// NewRemoteClient returns an HTTP client for fetching the remote keys, which refuses to connect to
+// internal addresses, or to follow redirects to other origins.
+func NewRemoteClient(timeout time.Duration) *http.Client {
+	dialer := net.Dialer{Timeout: timeout, Control: publicAddressOnly}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.DialContext = dialer.DialContext
+	// NOTE(marius): with a proxy we would be checking its address instead of the one of the remote server
+	tr.Proxy = nil
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: tr,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.Newf("too many redirects")
+			}
+			if !sameOrigin(vocab.IRI(req.URL.String()), vocab.IRI(via[0].URL.String())) {
+				return errors.Forbiddenf("refusing to follow redirect to %s", req.URL.Host)
+			}
+			return nil
+		},
+	}
+}
+
+type cachedKey struct {
+	key     crypto.PublicKey
+	owner   vocab.IRI
+	expires time.Time
+}
+
+// HTTPKeyResolver fetches the keys from their origin, and caches them for TTL.
+//
+// The owner claimed by the key document is trusted only when it's on the same origin as the key,
+// and its actor document lists the key in its publicKey, or assertionMethod properties.
+type HTTPKeyResolver struct {
+	Client *http.Client
+	TTL    time.Duration
+
+	mu   sync.Mutex
+	keys map[vocab.IRI]cachedKey
+}
+
+// NewHTTPKeyResolver returns a resolver that fetches the keys using a client which can only
+// connect to public addresses.
+func NewHTTPKeyResolver(ttl time.Duration) *HTTPKeyResolver {
+	if ttl <= 0 {
+		ttl = DefaultKeyCacheTTL
+	}
+	return &HTTPKeyResolver{Client: NewRemoteClient(DefaultKeyFetchTimeout), TTL: ttl}
+}
+
+// remoteKeyResolver is the resolver used by default, shared between the storage backends for its cache.
+var remoteKeyResolver = NewHTTPKeyResolver(DefaultKeyCacheTTL)
+
+func (h *HTTPKeyResolver) cached(keyID vocab.IRI) (cachedKey, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	k, ok := h.keys[keyID]
+	if !ok || time.Now().After(k.expires) {
+		return k, false
+	}
+	return k, true
+}
+
+func (h *HTTPKeyResolver) store(keyID vocab.IRI, k cachedKey) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.keys == nil {
+		h.keys = make(map[vocab.IRI]cachedKey)
+	}
+	if len(h.keys) >= maxCachedKeys {
+		now := time.Now()
+		for id, kk := range h.keys {
+			if now.After(kk.expires) {
+				delete(h.keys, id)
+			}
+		}
+		// NOTE(marius): when all keys are still valid, we evict a random one
+		for id := range h.keys {
+			if len(h.keys) < maxCachedKeys {
+				break
+			}
+			delete(h.keys, id)
+		}
+	}
+	h.keys[keyID] = k
+}
+
+func (h *HTTPKeyResolver) fetch(ctx context.Context, iri vocab.IRI) (remoteDocument, error) {
+	doc := remoteDocument{}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, iri.String(), nil)
+	if err != nil {
+		return doc, err
+	}
+	if req.URL.Scheme != "https" && req.URL.Scheme != "http" {
+		return doc, errors.BadRequestf("unsupported scheme for %s", iri)
+	}
+	req.Header.Set("Accept", `application/activity+json, application/ld+json; profile="https://www.w3.org/ns/activitystreams"`)
+
+	cl := h.Client
+	if cl == nil {
+		cl = remoteKeyResolver.Client
+	}
+	res, err := cl.Do(req)
+	if err != nil {
+		return doc, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return doc, errors.Newf("unable to fetch %s: %d %s", iri, res.StatusCode, http.StatusText(res.StatusCode))
+	}
+	if err = json.NewDecoder(io.LimitReader(res.Body, maxKeyDocumentSize)).Decode(&doc); err != nil {
+		return doc, errors.Annotatef(err, "invalid document %s", iri)
+	}
+	return doc, nil
+}
+
+func (h *HTTPKeyResolver) ResolveKey(ctx context.Context, keyID vocab.IRI) (crypto.PublicKey, vocab.IRI, error) {
+	if k, ok := h.cached(keyID); ok {
+		return k.key, k.owner, nil
+	}
+
+	doc, err := h.fetch(ctx, keyOwnerIRI(keyID))
+	if err != nil {
+		return nil, "", errors.Annotatef(err, "unable to fetch key %s", keyID)
+	}
+	owner := doc.claimedOwner(keyID)
+	if owner == "" {
+		return nil, "", errors.NotFoundf("key %s not found in the fetched document", keyID)
+	}
+	if !sameOrigin(owner, keyID) {
+		return nil, "", errors.Unauthorizedf("owner %s of key %s is on a different origin", owner, keyID)
+	}
+	actor := doc
+	if !owner.Equals(doc.ID, false) {
+		// NOTE(marius): a key document only claims its owner, so we load the actor to check it has the key
+		if actor, err = h.fetch(ctx, owner); err != nil {
+			return nil, "", errors.Annotatef(err, "unable to fetch owner %s of key %s", owner, keyID)
+		}
+		if !owner.Equals(actor.ID, false) {
+			return nil, "", errors.Unauthorizedf("invalid owner document %s for key %s", actor.ID, keyID)
+		}
+	}
+	if !actor.listsKey(keyID) {
+		return nil, "", errors.Unauthorizedf("owner %s doesn't list the key %s", owner, keyID)
+	}
+	pub, err := actor.keyOf(keyID, time.Now())
+	if errors.IsNotFound(err) {
+		// NOTE(marius): the actor references the key only by its IRI, so we use the key document's material
+		pub, err = h.referencedKey(ctx, doc, keyID, owner)
+	}
+	if err != nil {
+		return nil, "", errors.NewUnauthorized(err, "invalid key %s for owner %s", keyID, owner)
+	}
+
+	ttl := h.TTL
+	if ttl <= 0 {
+		ttl = DefaultKeyCacheTTL
+	}
+	h.store(keyID, cachedKey{key: pub, owner: owner, expires: time.Now().Add(ttl)})
+	return pub, owner, nil
+}
+
+// referencedKey loads the key that an actor references by its IRI. The key document is the one fetched
+// for the keyId, or when that was the actor's document, it is fetched separately.
+// The key is on the same origin as its owner, and it needs to claim the same owner.
+func (h *HTTPKeyResolver) referencedKey(ctx context.Context, doc remoteDocument, keyID, owner vocab.IRI) (crypto.PublicKey, error) {
+	keyDoc := doc
+	if keyDoc.ID != keyID {
+		var err error
+		if keyDoc, err = h.fetch(ctx, keyID); err != nil {
+			return nil, errors.Annotatef(err, "unable to fetch key %s", keyID)
+		}
+	}
+	if keyDoc.ID != keyID || !keyDoc.claimedOwner(keyID).Equals(owner, false) {
+		return nil, errors.Unauthorizedf("key document %s doesn't belong to %s", keyDoc.ID, owner)
+	}
+	return publicKeyFromPEM(keyDoc.PublicKeyPem)
+}
+
+// firstKeyResolver tries the resolvers in order, and returns the first key found.
+type firstKeyResolver []KeyResolver
+
+func (f firstKeyResolver) ResolveKey(ctx context.Context, keyID vocab.IRI) (crypto.PublicKey, vocab.IRI, error) {
+	errs := make([]error, 0, len(f))
+	for _, r := range f {
+		pub, owner, err := r.ResolveKey(ctx, keyID)
+		if err == nil {
+			return pub, owner, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, "", errors.Join(errs...)
+}
+
+// authorizeRequest returns the storage with the requester set to the actor that signed the request.
+//
+// NOTE(marius): when the verification is enabled, the requests without a signature are evaluated
+// as if made by the Public collection, so they can only see the public actors and objects.
+func (h handler) authorizeRequest(r *http.Request, storage Storage) (Storage, error) {
+	o := storage.Options.Signatures
+	if !o.Verify {
+		return storage, nil
+	}
+	resolver := o.Resolver
+	if resolver == nil {
+		resolver = firstKeyResolver{storageKeyResolver(storage), remoteKeyResolver}
+	}
+	storage.Requester = vocab.PublicNS
+	if r.Header.Get("Signature") == "" && !strings.HasPrefix(r.Header.Get("Authorization"), "Signature ") {
+		return storage, nil
+	}
+	requester, err := VerifyHTTPSignature(r, resolver)
+	if err != nil {
+		return storage, errors.NewUnauthorized(err, "invalid HTTP signature")
+	}
+	storage.Requester = requester
+	return storage, nil
+}
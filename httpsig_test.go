@@ -0,0 +1,363 @@
+package webfinger
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"git.sr.ht/~mariusor/lw"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+const testKeyID = vocab.IRI("https://remote.example.com/actors/jdoe#main-key")
+
+func signRequest(t *testing.T, r *http.Request, key crypto.Signer, headers ...string) {
+	t.Helper()
+	data, err := signingString(r, headers)
+	if err != nil {
+		t.Fatalf("unable to build signing string: %s", err)
+	}
+	var sig []byte
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(data))
+	case *rsa.PrivateKey:
+		sum := sha256.Sum256([]byte(data))
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum[:])
+	}
+	if err != nil {
+		t.Fatalf("unable to sign request: %s", err)
+	}
+	r.Header.Set("Signature", `keyId="`+testKeyID.String()+`",algorithm="hs2019",headers="`+
+		strings.Join(headers, " ")+`",signature="`+base64.StdEncoding.EncodeToString(sig)+`"`)
+}
+
+func testResolver(pub crypto.PublicKey) KeyResolver {
+	return KeyResolverFunc(func(_ context.Context, keyID vocab.IRI) (crypto.PublicKey, vocab.IRI, error) {
+		if keyID != testKeyID {
+			return nil, "", errors.NotFoundf("key not found %s", keyID)
+		}
+		return pub, keyOwnerIRI(keyID), nil
+	})
+}
+
+func newSignedRequest(t *testing.T, key crypto.Signer, date time.Time, headers ...string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/.well-known/webfinger?resource=acct:jdoe@example.com", nil)
+	r.Host = "example.com"
+	r.Header.Set("Date", date.UTC().Format(http.TimeFormat))
+	signRequest(t, r, key, headers...)
+	return r
+}
+
+func TestVerifyHTTPSignature(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unable to generate RSA key: %s", err)
+	}
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	signed := []string{"(request-target)", "host", "date"}
+	wantOwner := vocab.IRI("https://remote.example.com/actors/jdoe")
+
+	for name, key := range map[string]crypto.Signer{"Ed25519": edKey, "RSA": rsaKey} {
+		t.Run(name, func(t *testing.T) {
+			owner, err := VerifyHTTPSignature(newSignedRequest(t, key, time.Now(), signed...), testResolver(key.Public()))
+			if err != nil {
+				t.Fatalf("VerifyHTTPSignature() returned error %s", err)
+			}
+			if owner != wantOwner {
+				t.Errorf("Invalid requester %s, expected %s", owner, wantOwner)
+			}
+		})
+	}
+	t.Run("wrong key", func(t *testing.T) {
+		r := newSignedRequest(t, edKey, time.Now(), signed...)
+		if _, err := VerifyHTTPSignature(r, testResolver(otherKey.Public())); err == nil {
+			t.Errorf("VerifyHTTPSignature() should fail for a different key")
+		}
+	})
+	t.Run("modified target", func(t *testing.T) {
+		r := newSignedRequest(t, edKey, time.Now(), signed...)
+		r.URL.RawQuery = "resource=acct:admin@example.com"
+		if _, err := VerifyHTTPSignature(r, testResolver(edKey.Public())); err == nil {
+			t.Errorf("VerifyHTTPSignature() should fail for a modified request target")
+		}
+	})
+	t.Run("missing request target", func(t *testing.T) {
+		r := newSignedRequest(t, edKey, time.Now(), "host", "date")
+		if _, err := VerifyHTTPSignature(r, testResolver(edKey.Public())); err == nil {
+			t.Errorf("VerifyHTTPSignature() should fail when the request target is not signed")
+		}
+	})
+	t.Run("stale date", func(t *testing.T) {
+		r := newSignedRequest(t, edKey, time.Now().Add(-2*maxSignatureAge), signed...)
+		if _, err := VerifyHTTPSignature(r, testResolver(edKey.Public())); err == nil {
+			t.Errorf("VerifyHTTPSignature() should fail for an old date")
+		}
+	})
+}
+
+func TestHandler_authorizeRequest(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	h := handler{}
+	storage := Storage{
+		Root:    vocab.Actor{ID: "https://example.com/"},
+		Options: Options{Signatures: SignatureOptions{Verify: true, Resolver: testResolver(key.Public())}},
+	}
+
+	unsigned := httptest.NewRequest(http.MethodGet, "/.well-known/webfinger", nil)
+	st, err := h.authorizeRequest(unsigned, Storage{Root: storage.Root})
+	if err != nil || st.authorizedIRI() != storage.Root.ID {
+		t.Errorf("authorizeRequest() without verification = %s, %v, expected the root actor", st.authorizedIRI(), err)
+	}
+	st, err = h.authorizeRequest(unsigned, storage)
+	if err != nil || st.authorizedIRI() != vocab.PublicNS {
+		t.Errorf("authorizeRequest() for unsigned request = %s, %v, expected the public collection", st.authorizedIRI(), err)
+	}
+
+	signed := newSignedRequest(t, key, time.Now(), "(request-target)", "host", "date")
+	st, err = h.authorizeRequest(signed, storage)
+	if err != nil || st.authorizedIRI() != keyOwnerIRI(testKeyID) {
+		t.Errorf("authorizeRequest() for signed request = %s, %v, expected the key owner", st.authorizedIRI(), err)
+	}
+
+	signed.Header.Set("Date", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	if _, err = h.authorizeRequest(signed, storage); !errors.IsUnauthorized(err) {
+		t.Errorf("authorizeRequest() for invalid signature should return unauthorized error, got %v", err)
+	}
+}
+
+func TestHandler_privateActors(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	root := itemFromJSON(t, `{"id":"https://example.com","type":"Application"}`)
+	public := itemFromJSON(t, `{
+		"id": "https://example.com/actors/app",
+		"type": "Application",
+		"preferredUsername": "app",
+		"to": ["https://www.w3.org/ns/activitystreams#Public"]
+	}`)
+	// NOTE(marius): the private actor is visible to the root actor, which was used for all the requests
+	// before the signatures were verified.
+	private := itemFromJSON(t, `{
+		"id": "https://example.com/actors/hidden",
+		"type": "Application",
+		"preferredUsername": "hidden",
+		"attributedTo": "https://example.com",
+		"to": ["https://remote.example.com/actors/jdoe"]
+	}`)
+	db := WithOptions(newMockStore(root, public, private), Options{
+		Signatures: SignatureOptions{Verify: true, Resolver: testResolver(key.Public())},
+	})
+	h := handler{s: []Store{db}, l: lw.Dev(lw.SetLevel(lw.ErrorLevel))}
+
+	endpoints := map[string]struct {
+		path    string
+		handler http.HandlerFunc
+	}{
+		"WebFinger":       {path: WellKnownWebFingerPath + "?resource=acct:%s@example.com", handler: h.HandleWebFinger},
+		"DID document":    {path: "/actors/%s/did.json", handler: h.HandleDIDDocument},
+		"OpenID":          {path: "/actors/%s" + WellKnownOpenIDConfigurationPath, handler: h.HandleOpenIDConfiguration},
+		"OAuth2 metadata": {path: WellKnownOAuthAuthorizationServerPath + "/actors/%s", handler: h.HandleOAuthAuthorizationServer},
+		"JWKS":            {path: WellKnownJWKSPath + "/actors/%s", handler: h.HandleJWKS},
+		"client metadata": {path: DefaultClientMetadataPath + "/actors/%s", handler: h.HandleClientMetadata(DefaultClientMetadataPath)},
+		"interaction":     {path: AuthorizeInteractionPath + "?uri=https://example.com/actors/%s", handler: h.HandleAuthorizeInteraction},
+	}
+	for name, e := range endpoints {
+		t.Run(name, func(t *testing.T) {
+			tests := []struct {
+				name   string
+				signed bool
+				want   int
+			}{
+				{name: "app", want: http.StatusOK},
+				{name: "hidden", want: http.StatusNotFound},
+				{name: "hidden", signed: true, want: http.StatusOK},
+			}
+			for _, tt := range tests {
+				r := httptest.NewRequest(http.MethodGet, fmt.Sprintf(e.path, tt.name), nil)
+				r.Host = "example.com"
+				if tt.signed {
+					r.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+					signRequest(t, r, key, "(request-target)", "host", "date")
+				}
+				w := httptest.NewRecorder()
+				e.handler(w, r)
+				if w.Code != tt.want {
+					t.Errorf("%s %s signed %t, status %d, expected %d", name, r.URL, tt.signed, w.Code, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPKeyResolver(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	_, forgedKey, _ := ed25519.GenerateKey(rand.Reader)
+	keyPem := pemEncodePublicKey(t, key.Public())
+	multibase, _ := multibaseOf(key.Public())
+
+	docs := make(map[string]any)
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		doc, ok := docs[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(doc)
+	}))
+	defer srv.Close()
+
+	base := srv.URL
+	docs["/actors/jdoe"] = map[string]any{
+		"id":   base + "/actors/jdoe",
+		"type": "Person",
+		"publicKey": map[string]any{
+			"id":           base + "/actors/jdoe#main-key",
+			"owner":        base + "/actors/jdoe",
+			"publicKeyPem": keyPem,
+		},
+	}
+	docs["/actors/alice"] = map[string]any{
+		"id":        base + "/actors/alice",
+		"type":      "Person",
+		"publicKey": map[string]any{"id": base + "/keys/alice"},
+	}
+	docs["/keys/alice"] = map[string]any{
+		"id":           base + "/keys/alice",
+		"owner":        base + "/actors/alice",
+		"publicKeyPem": keyPem,
+	}
+	docs["/actors/bob"] = map[string]any{
+		"id":   base + "/actors/bob",
+		"type": "Person",
+		"assertionMethod": []any{map[string]any{
+			"id":                 base + "/actors/bob#ed25519-key",
+			"type":               "Multikey",
+			"controller":         base + "/actors/bob",
+			"publicKeyMultibase": multibase,
+		}},
+	}
+	docs["/actors/carol"] = map[string]any{
+		"id":        base + "/actors/carol",
+		"type":      "Person",
+		"publicKey": base + "/keys/carol",
+	}
+	docs["/keys/carol"] = map[string]any{
+		"id":           base + "/keys/carol",
+		"owner":        base + "/actors/carol",
+		"publicKeyPem": keyPem,
+	}
+	docs["/actors/dave"] = map[string]any{
+		"id":   base + "/actors/dave",
+		"type": "Person",
+		"publicKey": []any{
+			base + "/keys/dave-old",
+			map[string]any{"id": base + "/actors/dave#main-key", "owner": base + "/actors/dave", "publicKeyPem": keyPem},
+		},
+	}
+	docs["/keys/forged"] = map[string]any{
+		"id":           base + "/keys/forged",
+		"owner":        base + "/actors/jdoe",
+		"publicKeyPem": pemEncodePublicKey(t, forgedKey.Public()),
+	}
+	docs["/keys/cross-origin"] = map[string]any{
+		"id":           base + "/keys/cross-origin",
+		"owner":        "https://victim.example.com/actors/admin",
+		"publicKeyPem": pemEncodePublicKey(t, forgedKey.Public()),
+	}
+
+	tests := []struct {
+		name      string
+		keyID     vocab.IRI
+		wantOwner vocab.IRI
+		wantErr   bool
+	}{
+		{name: "actor publicKey", keyID: vocab.IRI(base + "/actors/jdoe#main-key"), wantOwner: vocab.IRI(base + "/actors/jdoe")},
+		{name: "key document", keyID: vocab.IRI(base + "/keys/alice"), wantOwner: vocab.IRI(base + "/actors/alice")},
+		{name: "assertionMethod", keyID: vocab.IRI(base + "/actors/bob#ed25519-key"), wantOwner: vocab.IRI(base + "/actors/bob")},
+		{name: "publicKey IRI", keyID: vocab.IRI(base + "/keys/carol"), wantOwner: vocab.IRI(base + "/actors/carol")},
+		{name: "publicKey list", keyID: vocab.IRI(base + "/actors/dave#main-key"), wantOwner: vocab.IRI(base + "/actors/dave")},
+		{name: "publicKey list missing key document", keyID: vocab.IRI(base + "/keys/dave-old"), wantErr: true},
+		{name: "forged owner", keyID: vocab.IRI(base + "/keys/forged"), wantErr: true},
+		{name: "cross origin owner", keyID: vocab.IRI(base + "/keys/cross-origin"), wantErr: true},
+		{name: "missing key", keyID: vocab.IRI(base + "/actors/jdoe#other-key"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := HTTPKeyResolver{Client: srv.Client()}
+			pub, owner, err := r.ResolveKey(context.Background(), tt.keyID)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ResolveKey(%s) = %s, expected error", tt.keyID, owner)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveKey(%s) returned error %s", tt.keyID, err)
+			}
+			if owner != tt.wantOwner {
+				t.Errorf("Invalid owner %s, expected %s", owner, tt.wantOwner)
+			}
+			if !key.Public().(ed25519.PublicKey).Equal(pub) {
+				t.Errorf("Invalid key resolved for %s", tt.keyID)
+			}
+		})
+	}
+
+	t.Run("cached", func(t *testing.T) {
+		r := HTTPKeyResolver{Client: srv.Client(), TTL: time.Minute}
+		keyID := vocab.IRI(base + "/keys/alice")
+		if _, _, err := r.ResolveKey(context.Background(), keyID); err != nil {
+			t.Fatalf("ResolveKey(%s) returned error %s", keyID, err)
+		}
+		before := calls.Load()
+		if _, _, err := r.ResolveKey(context.Background(), keyID); err != nil {
+			t.Fatalf("ResolveKey(%s) returned error %s", keyID, err)
+		}
+		if calls.Load() != before {
+			t.Errorf("Invalid number of requests %d, expected the key to be cached", calls.Load()-before)
+		}
+	})
+
+	t.Run("internal address", func(t *testing.T) {
+		keyID := vocab.IRI(base + "/actors/jdoe#main-key")
+		if _, _, err := NewHTTPKeyResolver(0).ResolveKey(context.Background(), keyID); err == nil {
+			t.Errorf("ResolveKey(%s) should refuse to connect to the loopback address", keyID)
+		}
+	})
+}
+
+func TestPublicAddressOnly(t *testing.T) {
+	tests := map[string]bool{
+		"93.184.215.14:443":      true,
+		"[2606:2800:21f::1]:443": true,
+		"127.0.0.1:80":           false,
+		"[::1]:80":               false,
+		"10.0.0.1:443":           false,
+		"192.168.1.1:443":        false,
+		"169.254.169.254:80":     false,
+		"100.64.0.1:443":         false,
+		"[::ffff:127.0.0.1]:80":  false,
+		"0.0.0.0:80":             false,
+	}
+	for addr, want := range tests {
+		if err := publicAddressOnly("tcp", addr, nil); (err == nil) != want {
+			t.Errorf("publicAddressOnly(%s) = %v, expected allowed %t", addr, err, want)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package webfinger
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/auth"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/filters"
+)
+
+// mastodonCompatibleVersion is the Mastodon API version we advertise to clients, as some of them
+// refuse to work with servers that don't report one they know about.
+const mastodonCompatibleVersion = "4.0.0"
+
+// InstanceOptions are the per storage values used for generating the Mastodon instance entities.
+type InstanceOptions struct {
+	// Email is the contact address for the instance administrators.
+	Email string
+	// ContactAccount is the IRI of the actor to be used as the instance contact, when missing
+	// we use the actor the root actor is attributed to.
+	ContactAccount string
+	Languages      []string
+	Rules          []string
+	// ApprovalRequired marks that new registrations need to be approved by the administrators.
+	ApprovalRequired bool
+}
+
+// InstanceAccount is the Mastodon account entity, it contains only the properties we can fill
+// from an ActivityPub actor.
+//
+// https://docs.joinmastodon.org/entities/Account/
+type InstanceAccount struct {
+	ID             string `json:"id"`
+	Username       string `json:"username"`
+	Acct           string `json:"acct"`
+	DisplayName    string `json:"display_name"`
+	Locked         bool   `json:"locked"`
+	Bot            bool   `json:"bot"`
+	Group          bool   `json:"group"`
+	CreatedAt      string `json:"created_at"`
+	Note           string `json:"note"`
+	URL            string `json:"url"`
+	Avatar         string `json:"avatar"`
+	AvatarStatic   string `json:"avatar_static"`
+	Header         string `json:"header"`
+	HeaderStatic   string `json:"header_static"`
+	FollowersCount int    `json:"followers_count"`
+	FollowingCount int    `json:"following_count"`
+	StatusesCount  int    `json:"statuses_count"`
+	Fields         []any  `json:"fields"`
+	Emojis         []any  `json:"emojis"`
+}
+
+// InstanceRule is the Mastodon rule entity.
+//
+// https://docs.joinmastodon.org/entities/Rule/
+type InstanceRule struct {
+	ID   string `json:"id"`
+	Text string `json:"text"`
+}
+
+// InstanceV1 is the Mastodon V1::Instance entity served at /api/v1/instance
+//
+// https://docs.joinmastodon.org/entities/V1_Instance/
+type InstanceV1 struct {
+	URI              string `json:"uri"`
+	Title            string `json:"title"`
+	ShortDescription string `json:"short_description"`
+	Description      string `json:"description"`
+	Email            string `json:"email"`
+	Version          string `json:"version"`
+	URLs             struct {
+		StreamingAPI string `json:"streaming_api"`
+	} `json:"urls"`
+	Stats struct {
+		UserCount   int `json:"user_count"`
+		StatusCount int `json:"status_count"`
+		DomainCount int `json:"domain_count"`
+	} `json:"stats"`
+	Thumbnail        string           `json:"thumbnail,omitempty"`
+	Languages        []string         `json:"languages"`
+	Registrations    bool             `json:"registrations"`
+	ApprovalRequired bool             `json:"approval_required"`
+	InvitesEnabled   bool             `json:"invites_enabled"`
+	ContactAccount   *InstanceAccount `json:"contact_account"`
+	Rules            []InstanceRule   `json:"rules"`
+}
+
+// InstanceV2 is the Mastodon Instance entity served at /api/v2/instance
+//
+// https://docs.joinmastodon.org/entities/Instance/
+type InstanceV2 struct {
+	Domain      string `json:"domain"`
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	SourceURL   string `json:"source_url"`
+	Description string `json:"description"`
+	Usage       struct {
+		Users struct {
+			ActiveMonth int `json:"active_month"`
+		} `json:"users"`
+	} `json:"usage"`
+	Thumbnail struct {
+		URL string `json:"url"`
+	} `json:"thumbnail"`
+	Languages     []string `json:"languages"`
+	Configuration struct {
+		URLs struct {
+			Streaming string `json:"streaming"`
+		} `json:"urls"`
+		Translation struct {
+			Enabled bool `json:"enabled"`
+		} `json:"translation"`
+	} `json:"configuration"`
+	Registrations struct {
+		Enabled          bool    `json:"enabled"`
+		ApprovalRequired bool    `json:"approval_required"`
+		Message          *string `json:"message"`
+	} `json:"registrations"`
+	Contact struct {
+		Email   string           `json:"email"`
+		Account *InstanceAccount `json:"account"`
+	} `json:"contact"`
+	Rules []InstanceRule `json:"rules"`
+}
+
+// AccountOf converts the actor to a Mastodon account entity.
+func AccountOf(actor vocab.Actor) InstanceAccount {
+	username := vocab.PreferredNameOf(actor)
+	acc := InstanceAccount{
+		ID:          string(actor.ID),
+		Username:    username,
+		Acct:        username,
+		DisplayName: vocab.NameOf(actor),
+		Bot:         filters.HasType(vocab.ServiceType, vocab.ApplicationType).Match(actor),
+		Group:       filters.HasType(vocab.GroupType).Match(actor),
+		Note:        vocab.SummaryOf(actor),
+		URL:         string(actor.ID),
+		Avatar:      IconOf(actor),
+		Fields:      []any{},
+		Emojis:      []any{},
+	}
+	if acc.DisplayName == "" {
+		acc.DisplayName = username
+	}
+	if !actor.Published.IsZero() {
+		acc.CreatedAt = actor.Published.UTC().Format(time.RFC3339)
+	}
+	if !vocab.IsNil(actor.URL) {
+		acc.URL = string(actor.URL.GetLink())
+	}
+	if !vocab.IsNil(actor.Image) {
+		acc.Header = string(actor.Image.GetLink())
+	}
+	acc.AvatarStatic = acc.Avatar
+	acc.HeaderStatic = acc.Header
+	return acc
+}
+
+func rulesOf(o InstanceOptions) []InstanceRule {
+	rules := make([]InstanceRule, 0, len(o.Rules))
+	for i, text := range o.Rules {
+		rules = append(rules, InstanceRule{ID: strconv.Itoa(i + 1), Text: text})
+	}
+	return rules
+}
+
+type instanceInfo struct {
+	app           vocab.Actor
+	web           WebInfo
+	usage         NodeInfoUsage
+	registrations bool
+	contact       *InstanceAccount
+	opts          Options
+}
+
+func (i instanceInfo) domain() string {
+	u, err := i.app.ID.URL()
+	if err != nil {
+		return string(i.app.ID)
+	}
+	return u.Host
+}
+
+func (i instanceInfo) version() string {
+	sw := SoftwareOf(i.app, i.opts.NodeInfo)
+	return fmt.Sprintf("%s (compatible; %s %s)", mastodonCompatibleVersion, sw.Name, sw.Version)
+}
+
+func (i instanceInfo) languages() []string {
+	if i.web.Languages == nil {
+		return []string{}
+	}
+	return i.web.Languages
+}
+
+func (i instanceInfo) V1() InstanceV1 {
+	in := InstanceV1{
+		URI:              i.domain(),
+		Title:            stripTags(i.web.Title),
+		ShortDescription: i.web.Summary,
+		Description:      i.web.Description,
+		Email:            i.web.Email,
+		Version:          i.version(),
+		Thumbnail:        i.web.Thumbnail,
+		Languages:        i.languages(),
+		Registrations:    i.registrations,
+		ApprovalRequired: i.opts.Instance.ApprovalRequired,
+		ContactAccount:   i.contact,
+		Rules:            rulesOf(i.opts.Instance),
+	}
+	if in.Description == "" {
+		in.Description = i.web.Summary
+	}
+	in.Stats.UserCount = i.usage.Users.Total
+	in.Stats.StatusCount = i.usage.LocalPosts + i.usage.LocalComments
+	return in
+}
+
+func (i instanceInfo) V2() InstanceV2 {
+	in := InstanceV2{
+		Domain:      i.domain(),
+		Title:       stripTags(i.web.Title),
+		Version:     i.version(),
+		SourceURL:   SoftwareOf(i.app, i.opts.NodeInfo).Repository,
+		Description: i.web.Summary,
+		Languages:   i.languages(),
+		Rules:       rulesOf(i.opts.Instance),
+	}
+	in.Usage.Users.ActiveMonth = i.usage.Users.ActiveMonth
+	in.Thumbnail.URL = i.web.Thumbnail
+	in.Registrations.Enabled = i.registrations
+	in.Registrations.ApprovalRequired = i.opts.Instance.ApprovalRequired
+	in.Contact.Email = i.web.Email
+	in.Contact.Account = i.contact
+	return in
+}
+
+func (h *handler) setupInstance(r *http.Request) (*instanceInfo, error) {
+	storage, err := h.findMatchingStorage(baseURL(r)...)
+	if err != nil {
+		return nil, err
+	}
+	app := storage.Root
+	if app.ID == "" || auth.AnonymousActor.Equals(app) {
+		return nil, errors.NotFoundf("root actor not found")
+	}
+
+	name := vocab.NameOf(app)
+	if name == "" {
+		name = vocab.PreferredNameOf(app)
+	}
+	o := storage.Options.Instance
+	info := instanceInfo{
+		app: app,
+		web: WebInfo{
+			Title:       name,
+			Email:       o.Email,
+			Summary:     vocab.SummaryOf(app),
+			Description: vocab.ContentOf(app),
+			Thumbnail:   IconOf(app),
+			Languages:   o.Languages,
+			URI:         string(app.ID),
+			Version:     Version,
+		},
+		registrations: OpenRegistrations(app, storage.Options),
+		opts:          storage.Options,
+	}
+	info.usage, _ = NodeInfoResolverNew(aggRepo(storage), app).Usage()
+
+	contactIRI := vocab.IRI(o.ContactAccount)
+	if contactIRI == "" && !vocab.IsNil(app.AttributedTo) {
+		contactIRI = app.AttributedTo.GetLink()
+	}
+	if contactIRI != "" {
+		if it, err := LoadActor(storage, filters.SameID(contactIRI)); err == nil && !vocab.IsNil(it) {
+			_ = vocab.OnActor(it, func(contact *vocab.Actor) error {
+				acc := AccountOf(*contact)
+				info.contact = &acc
+				return nil
+			})
+		}
+	}
+	return &info, nil
+}
+
+const (
+	InstanceV1Path = "/api/v1/instance"
+	InstanceV2Path = "/api/v2/instance"
+)
+
+// HandleInstanceV1 serves the Mastodon compatible /api/v1/instance
+func (h handler) HandleInstanceV1(w http.ResponseWriter, r *http.Request) {
+	info, err := h.setupInstance(r)
+	if err != nil {
+		handleErr(h.l)(r, err).ServeHTTP(w, r)
+		return
+	}
+	h.writeInstance(w, r, info.V1())
+}
+
+// HandleInstanceV2 serves the Mastodon compatible /api/v2/instance
+func (h handler) HandleInstanceV2(w http.ResponseWriter, r *http.Request) {
+	info, err := h.setupInstance(r)
+	if err != nil {
+		handleErr(h.l)(r, err).ServeHTTP(w, r)
+		return
+	}
+	h.writeInstance(w, r, info.V2())
+}
+
+func (h handler) writeInstance(w http.ResponseWriter, r *http.Request, in any) {
+	dat, err := json.Marshal(in)
+	if err != nil {
+		handleErr(h.l)(r, errors.Annotatef(err, "unable to marshal instance information")).ServeHTTP(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(dat)
+	h.l.Debugf("%s %s%s %d %s", r.Method, r.Host, r.RequestURI, http.StatusOK, http.StatusText(http.StatusOK))
+}
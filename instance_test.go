@@ -0,0 +1,76 @@
+package webfinger
+
+import (
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+func mockInstanceInfo() instanceInfo {
+	return instanceInfo{
+		app: vocab.Actor{ID: "https://example.com/"},
+		web: WebInfo{
+			Title:     "<b>Example</b>",
+			Email:     "admin@example.com",
+			Summary:   "Example instance",
+			Thumbnail: "https://example.com/icon.png",
+		},
+		usage: NodeInfoUsage{
+			Users:         NodeInfoUsers{Total: 3, ActiveMonth: 2},
+			LocalPosts:    10,
+			LocalComments: 5,
+		},
+		registrations: true,
+		contact:       &InstanceAccount{ID: "https://example.com/actors/admin", Username: "admin"},
+		opts: Options{
+			Instance: InstanceOptions{Rules: []string{"Be nice"}, ApprovalRequired: true},
+		},
+	}
+}
+
+func TestInstanceInfo_V1(t *testing.T) {
+	in := mockInstanceInfo().V1()
+	if in.URI != "example.com" {
+		t.Errorf("Invalid uri %q, expected %q", in.URI, "example.com")
+	}
+	if in.Title != "Example" {
+		t.Errorf("Invalid title %q, expected %q", in.Title, "Example")
+	}
+	if in.Stats.UserCount != 3 || in.Stats.StatusCount != 15 {
+		t.Errorf("Invalid stats %+v, expected 3 users and 15 statuses", in.Stats)
+	}
+	if !in.Registrations || !in.ApprovalRequired {
+		t.Errorf("Invalid registration values %t/%t, expected true/true", in.Registrations, in.ApprovalRequired)
+	}
+	if in.ContactAccount == nil || in.ContactAccount.Username != "admin" {
+		t.Errorf("Invalid contact account %+v", in.ContactAccount)
+	}
+	if len(in.Rules) != 1 || in.Rules[0].ID != "1" || in.Rules[0].Text != "Be nice" {
+		t.Errorf("Invalid rules %+v", in.Rules)
+	}
+	if in.Languages == nil {
+		t.Errorf("Languages should be an empty list, not null")
+	}
+}
+
+func TestInstanceInfo_V2(t *testing.T) {
+	in := mockInstanceInfo().V2()
+	if in.Domain != "example.com" {
+		t.Errorf("Invalid domain %q, expected %q", in.Domain, "example.com")
+	}
+	if in.Usage.Users.ActiveMonth != 2 {
+		t.Errorf("Invalid monthly active users %d, expected %d", in.Usage.Users.ActiveMonth, 2)
+	}
+	if in.Thumbnail.URL != "https://example.com/icon.png" {
+		t.Errorf("Invalid thumbnail %q", in.Thumbnail.URL)
+	}
+	if !in.Registrations.Enabled || !in.Registrations.ApprovalRequired {
+		t.Errorf("Invalid registrations %+v", in.Registrations)
+	}
+	if in.Contact.Email != "admin@example.com" || in.Contact.Account == nil {
+		t.Errorf("Invalid contact %+v", in.Contact)
+	}
+	if in.SourceURL != sourceURL {
+		t.Errorf("Invalid source URL %q, expected %q", in.SourceURL, sourceURL)
+	}
+}
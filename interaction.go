@@ -0,0 +1,232 @@
+package webfinger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/filters"
+)
+
+// OStatusSubscribeRel is the WebFinger link relation used by remote follow, its template
+// points to the end-point where the local users can interact with a remote resource.
+const OStatusSubscribeRel = "http://ostatus.org/schema/1.0/subscribe"
+
+const AuthorizeInteractionPath = "/authorize_interaction"
+
+// subscribeTemplate returns the template of the OStatus subscribe link for host.
+func subscribeTemplate(host string) string {
+	return fmt.Sprintf("https://%s%s?uri={uri}", host, AuthorizeInteractionPath)
+}
+
+// interactionURL returns the URL of the client where the interaction with the uri takes place.
+// The "{uri}" placeholder in the configured client URL is replaced with the escaped uri,
+// and when it's missing, the uri is added as a query parameter.
+func interactionURL(client string, uri vocab.IRI) string {
+	escaped := url.QueryEscape(uri.String())
+	if strings.Contains(client, "{uri}") {
+		return strings.ReplaceAll(client, "{uri}", escaped)
+	}
+	sep := "?"
+	if strings.Contains(client, "?") {
+		sep = "&"
+	}
+	return client + sep + "uri=" + escaped
+}
+
+var interactionTpl = template.Must(template.New("interaction").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"/><title>{{ .Title }}</title></head>
+<body>
+<h1>{{ .Title }}</h1>
+<p>To interact with <a href="{{ .URL }}">{{ .Name }}</a>, open it in your ActivityPub client.</p>
+</body>
+</html>
+`))
+
+// WebFingerClient resolves the acct: URIs of the remote actors using the WebFinger end-point of their domain.
+type WebFingerClient struct {
+	Client *http.Client
+}
+
+// remoteWebFinger is the client used by default for resolving the remote handles.
+var remoteWebFinger = WebFingerClient{Client: NewRemoteClient(DefaultKeyFetchTimeout)}
+
+// maxJRDSize is the maximum size of a remote WebFinger response that we decode.
+const maxJRDSize = 64 * 1024
+
+// ResolveAccount returns the IRI of the actor that the acct: URI identifies, from the self link
+// of the JRD document served by its domain.
+func (c WebFingerClient) ResolveAccount(ctx context.Context, acct string) (vocab.IRI, error) {
+	_, handle := splitResourceString(acct)
+	_, domain, ok := strings.Cut(handle, "@")
+	if !ok || domain == "" {
+		return "", errors.BadRequestf("invalid account %s", acct)
+	}
+	u := url.URL{
+		Scheme:   "https",
+		Host:     domain,
+		Path:     WellKnownWebFingerPath,
+		RawQuery: url.Values{"resource": []string{acct}}.Encode(),
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", errors.NewBadRequest(err, "invalid account %s", acct)
+	}
+	req.Header.Set("Accept", "application/jrd+json, application/json")
+
+	cl := c.Client
+	if cl == nil {
+		cl = remoteWebFinger.Client
+	}
+	res, err := cl.Do(req)
+	if err != nil {
+		return "", errors.NewBadGateway(err, "unable to resolve %s", acct)
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusGone:
+		return "", errors.NotFoundf("account not found %s", acct)
+	default:
+		return "", errors.BadGatewayf("unable to resolve %s: %d %s", acct, res.StatusCode, http.StatusText(res.StatusCode))
+	}
+	wf := node{}
+	if err = json.NewDecoder(io.LimitReader(res.Body, maxJRDSize)).Decode(&wf); err != nil {
+		return "", errors.NewBadGateway(err, "invalid WebFinger response for %s", acct)
+	}
+	for _, l := range wf.Links {
+		if l.Rel != "self" || l.Href == "" {
+			continue
+		}
+		if l.Type == "application/activity+json" || strings.HasPrefix(l.Type, "application/ld+json") {
+			return vocab.IRI(l.Href), nil
+		}
+	}
+	return "", errors.NotFoundf("account %s has no ActivityPub actor", acct)
+}
+
+// isLocalDomain returns true if the domain is the one of the storage, which is either the one the
+// request was made to, the host of the root actor, or the configured account domain.
+func isLocalDomain(storage Storage, host, domain string) bool {
+	if strings.EqualFold(domain, host) {
+		return true
+	}
+	if d := storage.Options.WebFinger.AccountDomain; d != "" && strings.EqualFold(domain, d) {
+		return true
+	}
+	u, err := storage.Root.ID.URL()
+	return err == nil && strings.EqualFold(domain, u.Host)
+}
+
+// interactionTarget resolves the uri parameter of an interaction request.
+//
+// The local objects are loaded from the storage, and the local handles are resolved like for the WebFinger
+// requests. The handles of remote actors are resolved using the WebFinger end-point of their domain,
+// while the remote IRIs are returned as they are, as it's the client's job to load them.
+func interactionTarget(ctx context.Context, storage Storage, host, uri string) (vocab.Item, error) {
+	uri = normalizeResource(uri)
+	typ, handle := splitResourceString(uri)
+	switch typ {
+	case "acct":
+		_, domain, _ := strings.Cut(handle, "@")
+		if domain != "" && !isLocalDomain(storage, host, domain) {
+			iri, err := remoteWebFinger.ResolveAccount(ctx, uri)
+			if err != nil {
+				return nil, err
+			}
+			return iri, nil
+		}
+		wf, err := resolveResource(storage, host, uri, nil)
+		if err != nil {
+			return nil, err
+		}
+		uri = wf.Links[0].Href
+	case "https", "http":
+		u, err := url.Parse(uri)
+		if err != nil {
+			return nil, errors.NewBadRequest(err, "invalid uri %s", uri)
+		}
+		if !isLocalDomain(storage, host, u.Host) {
+			return vocab.IRI(uri), nil
+		}
+	default:
+		return nil, errors.BadRequestf("invalid uri %s", uri)
+	}
+	ob, err := LoadIRI(storage, vocab.IRI(uri), filters.Any(FilterURL(uri), FilterID(uri)))
+	if err != nil {
+		return nil, errors.NewNotFound(err, "resource not found %s", uri)
+	}
+	if vocab.IsNil(ob) {
+		return nil, errors.NotFoundf("resource not found %s", uri)
+	}
+	return ob, nil
+}
+
+// HandleAuthorizeInteraction serves /authorize_interaction?uri=
+//
+// It resolves the uri, which can be an IRI or an acct: handle of a local or a remote actor, and redirects
+// to the configured client URL, or when that's missing, it shows a minimal confirmation page.
+func (h handler) HandleAuthorizeInteraction(w http.ResponseWriter, r *http.Request) {
+	storage, err := h.findMatchingStorage(baseURL(r)...)
+	if err != nil {
+		handleErr(h.l)(r, err).ServeHTTP(w, r)
+		return
+	}
+	if storage, err = h.authorizeRequest(r, storage); err != nil {
+		handleErr(h.l)(r, err).ServeHTTP(w, r)
+		return
+	}
+
+	uri := r.URL.Query().Get("uri")
+	if uri == "" {
+		handleErr(h.l)(r, errors.BadRequestf("missing uri parameter")).ServeHTTP(w, r)
+		return
+	}
+	ob, err := interactionTarget(r.Context(), storage, r.Host, uri)
+	if err != nil {
+		handleErr(h.l)(r, err).ServeHTTP(w, r)
+		return
+	}
+
+	if client := storage.Options.InteractionURL; client != "" {
+		http.Redirect(w, r, interactionURL(client, ob.GetLink()), http.StatusFound)
+		h.l.Debugf("%s %s%s %d %s", r.Method, r.Host, r.RequestURI, http.StatusFound, http.StatusText(http.StatusFound))
+		return
+	}
+
+	name := vocab.NameOf(ob)
+	if name == "" {
+		name = vocab.PreferredNameOf(ob)
+	}
+	if name == "" {
+		name = ob.GetLink().String()
+	}
+	page := bytes.Buffer{}
+	err = interactionTpl.Execute(&page, struct {
+		Title string
+		Name  string
+		URL   string
+	}{
+		Title: "Interact with " + name,
+		Name:  name,
+		URL:   ob.GetLink().String(),
+	})
+	if err != nil {
+		handleErr(h.l)(r, errors.Annotatef(err, "unable to render interaction page")).ServeHTTP(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(page.Bytes())
+	h.l.Debugf("%s %s%s %d %s", r.Method, r.Host, r.RequestURI, http.StatusOK, http.StatusText(http.StatusOK))
+}
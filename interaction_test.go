@@ -0,0 +1,161 @@
+package webfinger
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"git.sr.ht/~mariusor/lw"
+	vocab "github.com/go-ap/activitypub"
+)
+
+func TestInteractionURL(t *testing.T) {
+	uri := "https://example.com/actors/jdoe"
+	tests := []struct {
+		client string
+		want   string
+	}{
+		{
+			client: "https://client.example.com/follow",
+			want:   "https://client.example.com/follow?uri=https%3A%2F%2Fexample.com%2Factors%2Fjdoe",
+		},
+		{
+			client: "https://client.example.com/follow?lang=en",
+			want:   "https://client.example.com/follow?lang=en&uri=https%3A%2F%2Fexample.com%2Factors%2Fjdoe",
+		},
+		{
+			client: "https://client.example.com/interact/{uri}",
+			want:   "https://client.example.com/interact/https%3A%2F%2Fexample.com%2Factors%2Fjdoe",
+		},
+	}
+	for _, tt := range tests {
+		if got := interactionURL(tt.client, vocab.IRI(uri)); got != tt.want {
+			t.Errorf("interactionURL(%q, %q) = %q, want %q", tt.client, uri, got, tt.want)
+		}
+	}
+}
+
+func TestSubscribeTemplate(t *testing.T) {
+	want := "https://example.com/authorize_interaction?uri={uri}"
+	if got := subscribeTemplate("example.com"); got != want {
+		t.Errorf("subscribeTemplate() = %q, want %q", got, want)
+	}
+}
+
+func TestResolveResource_subscribeLink(t *testing.T) {
+	root := itemFromJSON(t, `{"id":"https://example.com","type":"Application"}`)
+	actor := itemFromJSON(t, `{"id":"https://example.com/actors/jdoe","type":"Person","preferredUsername":"jdoe","to":["https://www.w3.org/ns/activitystreams#Public"]}`)
+	note := itemFromJSON(t, `{"id":"https://example.com/objects/1","type":"Note","attributedTo":"https://example.com/actors/jdoe","to":["https://www.w3.org/ns/activitystreams#Public"]}`)
+	self, _ := vocab.ToActor(root)
+	storage := Storage{Store: newMockStore(root, actor, note), Root: *self}
+
+	tests := []struct {
+		res  string
+		q    url.Values
+		want bool
+	}{
+		{res: "acct:jdoe@example.com", want: true},
+		{res: "https://example.com/objects/1", want: false},
+		{res: "https://example.com/objects/1", q: url.Values{"attributedTo": []string{"true"}}, want: true},
+	}
+	for _, tt := range tests {
+		wf, err := resolveResource(storage, "example.com", tt.res, tt.q)
+		if err != nil {
+			t.Fatalf("resolveResource(%s) returned error %s", tt.res, err)
+		}
+		got := slices.ContainsFunc(wf.Links, func(l link) bool { return l.Rel == OStatusSubscribeRel })
+		if got != tt.want {
+			t.Errorf("resolveResource(%s) subscribe link %t, expected %t: %+v", tt.res, got, tt.want, wf.Links)
+		}
+	}
+}
+
+// remoteWebFingerServer starts a server answering the WebFinger requests for every domain
+// with the JRD documents in accounts, and makes it the remote WebFinger client for the test.
+func remoteWebFingerServer(t *testing.T, accounts map[string]string) {
+	t.Helper()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		href, ok := accounts[r.URL.Query().Get("resource")]
+		if !ok || r.URL.Path != WellKnownWebFingerPath {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(node{
+			Subject: r.URL.Query().Get("resource"),
+			Links:   []link{{Rel: "self", Type: "application/activity+json", Href: href}},
+		})
+	}))
+	t.Cleanup(srv.Close)
+
+	tr := srv.Client().Transport.(*http.Transport).Clone()
+	tr.TLSClientConfig.InsecureSkipVerify = true
+	tr.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+	}
+	prev := remoteWebFinger
+	remoteWebFinger = WebFingerClient{Client: &http.Client{Transport: tr}}
+	t.Cleanup(func() { remoteWebFinger = prev })
+}
+
+func TestHandler_HandleAuthorizeInteraction(t *testing.T) {
+	remoteWebFingerServer(t, map[string]string{"acct:alice@social.example": "https://social.example/users/alice"})
+
+	root := itemFromJSON(t, `{"id":"https://example.com","type":"Application"}`)
+	actor := itemFromJSON(t, `{"id":"https://example.com/actors/jdoe","type":"Person","preferredUsername":"jdoe","to":["https://www.w3.org/ns/activitystreams#Public"]}`)
+	db := newMockStore(root, actor)
+	h := handler{
+		s: []Store{WithOptions(db, Options{InteractionURL: "https://client.example/follow"})},
+		l: lw.Dev(lw.SetLevel(lw.ErrorLevel)),
+	}
+
+	tests := []struct {
+		name   string
+		uri    string
+		status int
+		target string
+	}{
+		{name: "local actor", uri: "https://example.com/actors/jdoe", status: http.StatusFound, target: "https://example.com/actors/jdoe"},
+		{name: "local handle", uri: "acct:jdoe@example.com", status: http.StatusFound, target: "https://example.com/actors/jdoe"},
+		{name: "bare local handle", uri: "@jdoe@example.com", status: http.StatusFound, target: "https://example.com/actors/jdoe"},
+		{name: "remote handle", uri: "acct:alice@social.example", status: http.StatusFound, target: "https://social.example/users/alice"},
+		{name: "remote actor", uri: "https://social.example/users/bob", status: http.StatusFound, target: "https://social.example/users/bob"},
+		{name: "missing local actor", uri: "https://example.com/actors/mallory", status: http.StatusNotFound},
+		{name: "missing local handle", uri: "acct:mallory@example.com", status: http.StatusNotFound},
+		{name: "missing remote handle", uri: "acct:mallory@social.example", status: http.StatusNotFound},
+		{name: "missing uri", uri: "", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, AuthorizeInteractionPath+"?uri="+url.QueryEscape(tt.uri), nil)
+			r.Host = "example.com"
+			w := httptest.NewRecorder()
+			h.HandleAuthorizeInteraction(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("HandleAuthorizeInteraction(%s) status %d, expected %d", tt.uri, w.Code, tt.status)
+			}
+			if tt.target == "" {
+				return
+			}
+			if loc, want := w.Header().Get("Location"), interactionURL("https://client.example/follow", vocab.IRI(tt.target)); loc != want {
+				t.Errorf("HandleAuthorizeInteraction(%s) redirected to %s, expected %s", tt.uri, loc, want)
+			}
+		})
+	}
+
+	t.Run("confirmation page", func(t *testing.T) {
+		h := handler{s: []Store{db}, l: lw.Dev(lw.SetLevel(lw.ErrorLevel))}
+		r := httptest.NewRequest(http.MethodGet, AuthorizeInteractionPath+"?uri="+url.QueryEscape("acct:jdoe@example.com"), nil)
+		r.Host = "example.com"
+		w := httptest.NewRecorder()
+		h.HandleAuthorizeInteraction(w, r)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `href="https://example.com/actors/jdoe"`) {
+			t.Errorf("HandleAuthorizeInteraction() = %d %s, expected the confirmation page for the actor", w.Code, w.Body.String())
+		}
+	})
+}
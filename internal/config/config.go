@@ -1,6 +1,7 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path"
@@ -54,6 +55,81 @@ type StorageConfig struct {
 	Path string
 }
 
+// NodeInfoOptions are the values that override the software identity and metadata
+// that get advertised in the NodeInfo documents.
+type NodeInfoOptions struct {
+	SoftwareName       string
+	SoftwareVersion    string
+	SoftwareRepository string
+	SoftwareHomepage   string
+	Private            bool
+	StaffAccounts      []string
+	Metadata           map[string]any
+	Protocols          []string
+	Feeds              bool
+	InboundServices    []string
+	OutboundServices   []string
+}
+
+// InstanceOptions are the values used for the Mastodon compatible instance end-points.
+type InstanceOptions struct {
+	Email            string
+	ContactAccount   string
+	Languages        []string
+	Rules            []string
+	ApprovalRequired bool
+}
+
+// WebFingerOptions are the values used for the WebFinger end-point.
+type WebFingerOptions struct {
+	PublicKeyLinks bool
+	AccountDomain  string
+	Strict         bool
+	BatchTokens    []string
+	// Search enables the handle search end-point, SearchIndexTTL is the duration after which its index gets rebuilt.
+	Search         bool
+	SearchIndexTTL time.Duration
+}
+
+// OAuthOptions are the values used for the OAuth2 metadata end-points.
+type OAuthOptions struct {
+	Scopes                       []string
+	GrantTypes                   []string
+	TokenEndpointAuthMethods     []string
+	TokenEndpointAuthSigningAlgs []string
+	CodeChallengeMethods         []string
+	DPoPSigningAlgs              []string
+	// ClientIDMetadataDocument is nil when the support has not been configured.
+	ClientIDMetadataDocument *bool
+	RevocationEndpoint       string
+	IntrospectionEndpoint    string
+	ServiceDocumentation     string
+	// SignMetadata enables the signed_metadata value, SigningKeyPath is the PEM private key used for it,
+	// when empty the service actor's key is used.
+	SignMetadata   bool
+	SigningKeyPath string
+	// ProbeURL is the URL of the authorize service capabilities document, when empty we don't probe.
+	ProbeURL string
+	ProbeTTL time.Duration
+	// Issuers contains the values that override the ones above for specific issuer actors, indexed by their IRI.
+	Issuers map[string]OAuthIssuerOptions
+}
+
+// OAuthIssuerOptions are the OAuth2 values specific to an issuer actor, the missing ones are not overridden.
+type OAuthIssuerOptions struct {
+	Scopes                       []string `json:"scopes,omitempty"`
+	GrantTypes                   []string `json:"grant_types,omitempty"`
+	TokenEndpointAuthMethods     []string `json:"token_endpoint_auth_methods,omitempty"`
+	TokenEndpointAuthSigningAlgs []string `json:"token_endpoint_auth_signing_algs,omitempty"`
+	CodeChallengeMethods         []string `json:"code_challenge_methods,omitempty"`
+	DPoPSigningAlgs              []string `json:"dpop_signing_algs,omitempty"`
+	ClientIDMetadataDocument     *bool    `json:"client_id_metadata_document,omitempty"`
+	RevocationEndpoint           string   `json:"revocation_endpoint,omitempty"`
+	IntrospectionEndpoint        string   `json:"introspection_endpoint,omitempty"`
+	ServiceDocumentation         string   `json:"service_documentation,omitempty"`
+	SigningKeyPath               string   `json:"signing_key_path,omitempty"`
+}
+
 type Options struct {
 	Env       Env
 	LogLevel  lw.Level
@@ -65,6 +141,15 @@ type Options struct {
 	Host      string
 	Listen    string
 	Storage   StorageConfig
+	// OpenRegistrations is nil when the registration status has not been configured.
+	OpenRegistrations *bool
+	InteractionURL    string
+	WebFinger         WebFingerOptions
+	NodeInfo          NodeInfoOptions
+	Instance          InstanceOptions
+	OAuth             OAuthOptions
+	// VerifySignatures enables the verification of the HTTP Signatures of the incoming requests.
+	VerifySignatures bool
 }
 
 type StorageType string
@@ -82,6 +167,51 @@ const (
 	KeyStorage     = "STORAGE"
 	KeyStoragePath = "STORAGE_PATH"
 
+	KeyOpenRegistrations = "OPEN_REGISTRATIONS"
+	KeyInteractionURL    = "INTERACTION_URL"
+
+	KeyWebFingerPublicKeyLinks = "WEBFINGER_PUBLIC_KEY_LINKS"
+	KeyWebFingerAccountDomain  = "WEBFINGER_ACCOUNT_DOMAIN"
+	KeyWebFingerStrict         = "WEBFINGER_STRICT"
+	KeyWebFingerBatchTokens    = "WEBFINGER_BATCH_TOKENS"
+	KeyWebFingerSearch         = "WEBFINGER_SEARCH"
+	KeyWebFingerSearchIndexTTL = "WEBFINGER_SEARCH_INDEX_TTL"
+	KeyVerifySignatures        = "HTTP_SIGNATURES_VERIFY"
+
+	KeyInstanceEmail            = "INSTANCE_EMAIL"
+	KeyInstanceContactAccount   = "INSTANCE_CONTACT_ACCOUNT"
+	KeyInstanceLanguages        = "INSTANCE_LANGUAGES"
+	KeyInstanceRules            = "INSTANCE_RULES"
+	KeyInstanceApprovalRequired = "INSTANCE_APPROVAL_REQUIRED"
+
+	KeyOAuthScopes                       = "OAUTH_SCOPES"
+	KeyOAuthGrantTypes                   = "OAUTH_GRANT_TYPES"
+	KeyOAuthTokenEndpointAuthMethods     = "OAUTH_TOKEN_ENDPOINT_AUTH_METHODS"
+	KeyOAuthTokenEndpointAuthSigningAlgs = "OAUTH_TOKEN_ENDPOINT_AUTH_SIGNING_ALGS"
+	KeyOAuthCodeChallengeMethods         = "OAUTH_CODE_CHALLENGE_METHODS"
+	KeyOAuthDPoPSigningAlgs              = "OAUTH_DPOP_SIGNING_ALGS"
+	KeyOAuthClientIDMetadataDocument     = "OAUTH_CLIENT_ID_METADATA_DOCUMENT"
+	KeyOAuthRevocationEndpoint           = "OAUTH_REVOCATION_ENDPOINT"
+	KeyOAuthIntrospectionEndpoint        = "OAUTH_INTROSPECTION_ENDPOINT"
+	KeyOAuthServiceDocumentation         = "OAUTH_SERVICE_DOCUMENTATION"
+	KeyOAuthSignMetadata                 = "OAUTH_SIGN_METADATA"
+	KeyOAuthSigningKeyPath               = "OAUTH_SIGNING_KEY_PATH"
+	KeyOAuthProbeURL                     = "OAUTH_PROBE_URL"
+	KeyOAuthProbeTTL                     = "OAUTH_PROBE_TTL"
+	KeyOAuthIssuers                      = "OAUTH_ISSUERS"
+
+	KeyNodeInfoSoftwareName       = "NODEINFO_SOFTWARE_NAME"
+	KeyNodeInfoSoftwareVersion    = "NODEINFO_SOFTWARE_VERSION"
+	KeyNodeInfoSoftwareRepository = "NODEINFO_SOFTWARE_REPOSITORY"
+	KeyNodeInfoSoftwareHomepage   = "NODEINFO_SOFTWARE_HOMEPAGE"
+	KeyNodeInfoPrivate            = "NODEINFO_PRIVATE"
+	KeyNodeInfoStaffAccounts      = "NODEINFO_STAFF_ACCOUNTS"
+	KeyNodeInfoMetadata           = "NODEINFO_METADATA"
+	KeyNodeInfoProtocols          = "NODEINFO_PROTOCOLS"
+	KeyNodeInfoFeeds              = "NODEINFO_FEEDS"
+	KeyNodeInfoInboundServices    = "NODEINFO_SERVICES_INBOUND"
+	KeyNodeInfoOutboundServices   = "NODEINFO_SERVICES_OUTBOUND"
+
 	StorageFS     = "fs"
 	StorageBoltDB = "boltdb"
 	StorageBadger = "badger"
@@ -173,9 +303,92 @@ func LoadFromEnv(e Env, timeOut time.Duration) (Options, error) {
 	conf.Storage.Path = path
 	conf.Storage.Path = normalizeStoragePath(path, conf.Storage, e)
 
+	if reg, err := strconv.ParseBool(Getval(KeyOpenRegistrations, "")); err == nil {
+		conf.OpenRegistrations = &reg
+	}
+	conf.InteractionURL = Getval(KeyInteractionURL, "")
+	conf.WebFinger.PublicKeyLinks, _ = strconv.ParseBool(Getval(KeyWebFingerPublicKeyLinks, ""))
+	conf.WebFinger.AccountDomain = Getval(KeyWebFingerAccountDomain, "")
+	conf.WebFinger.Strict, _ = strconv.ParseBool(Getval(KeyWebFingerStrict, ""))
+	conf.WebFinger.BatchTokens = splitList(Getval(KeyWebFingerBatchTokens, ""))
+	conf.WebFinger.Search, _ = strconv.ParseBool(Getval(KeyWebFingerSearch, ""))
+	conf.WebFinger.SearchIndexTTL, _ = time.ParseDuration(Getval(KeyWebFingerSearchIndexTTL, ""))
+	conf.VerifySignatures, _ = strconv.ParseBool(Getval(KeyVerifySignatures, ""))
+
+	conf.Instance.Email = Getval(KeyInstanceEmail, "")
+	conf.Instance.ContactAccount = Getval(KeyInstanceContactAccount, "")
+	conf.Instance.Languages = splitList(Getval(KeyInstanceLanguages, ""))
+	conf.Instance.ApprovalRequired, _ = strconv.ParseBool(Getval(KeyInstanceApprovalRequired, "false"))
+	if rules := Getval(KeyInstanceRules, ""); rules != "" {
+		// NOTE(marius): the rules are expected to be a JSON array of strings
+		if err := json.Unmarshal([]byte(rules), &conf.Instance.Rules); err != nil {
+			return conf, fmt.Errorf("invalid value for %s: %w", KeyInstanceRules, err)
+		}
+	}
+
+	conf.OAuth.Scopes = splitList(Getval(KeyOAuthScopes, ""))
+	conf.OAuth.GrantTypes = splitList(Getval(KeyOAuthGrantTypes, ""))
+	conf.OAuth.TokenEndpointAuthMethods = splitList(Getval(KeyOAuthTokenEndpointAuthMethods, ""))
+	conf.OAuth.TokenEndpointAuthSigningAlgs = splitList(Getval(KeyOAuthTokenEndpointAuthSigningAlgs, ""))
+	conf.OAuth.CodeChallengeMethods = splitListOrNone(Getval(KeyOAuthCodeChallengeMethods, ""))
+	conf.OAuth.DPoPSigningAlgs = splitList(Getval(KeyOAuthDPoPSigningAlgs, ""))
+	if cimd, err := strconv.ParseBool(Getval(KeyOAuthClientIDMetadataDocument, "")); err == nil {
+		conf.OAuth.ClientIDMetadataDocument = &cimd
+	}
+	conf.OAuth.RevocationEndpoint = Getval(KeyOAuthRevocationEndpoint, "")
+	conf.OAuth.IntrospectionEndpoint = Getval(KeyOAuthIntrospectionEndpoint, "")
+	conf.OAuth.ServiceDocumentation = Getval(KeyOAuthServiceDocumentation, "")
+	conf.OAuth.SignMetadata, _ = strconv.ParseBool(Getval(KeyOAuthSignMetadata, ""))
+	conf.OAuth.SigningKeyPath = Getval(KeyOAuthSigningKeyPath, "")
+	conf.OAuth.ProbeURL = Getval(KeyOAuthProbeURL, "")
+	conf.OAuth.ProbeTTL, _ = time.ParseDuration(Getval(KeyOAuthProbeTTL, ""))
+	if issuers := Getval(KeyOAuthIssuers, ""); issuers != "" {
+		// NOTE(marius): the issuer values are expected to be a JSON object indexed by the IRIs of the issuers
+		if err := json.Unmarshal([]byte(issuers), &conf.OAuth.Issuers); err != nil {
+			return conf, fmt.Errorf("invalid value for %s: %w", KeyOAuthIssuers, err)
+		}
+	}
+
+	conf.NodeInfo.SoftwareName = Getval(KeyNodeInfoSoftwareName, "")
+	conf.NodeInfo.SoftwareVersion = Getval(KeyNodeInfoSoftwareVersion, "")
+	conf.NodeInfo.SoftwareRepository = Getval(KeyNodeInfoSoftwareRepository, "")
+	conf.NodeInfo.SoftwareHomepage = Getval(KeyNodeInfoSoftwareHomepage, "")
+	conf.NodeInfo.Private, _ = strconv.ParseBool(Getval(KeyNodeInfoPrivate, "false"))
+	conf.NodeInfo.StaffAccounts = splitList(Getval(KeyNodeInfoStaffAccounts, ""))
+	conf.NodeInfo.Protocols = splitList(Getval(KeyNodeInfoProtocols, ""))
+	conf.NodeInfo.Feeds, _ = strconv.ParseBool(Getval(KeyNodeInfoFeeds, "false"))
+	conf.NodeInfo.InboundServices = splitList(Getval(KeyNodeInfoInboundServices, ""))
+	conf.NodeInfo.OutboundServices = splitList(Getval(KeyNodeInfoOutboundServices, ""))
+	if meta := Getval(KeyNodeInfoMetadata, ""); meta != "" {
+		// NOTE(marius): the extra metadata is expected to be a JSON object
+		if err := json.Unmarshal([]byte(meta), &conf.NodeInfo.Metadata); err != nil {
+			return conf, fmt.Errorf("invalid value for %s: %w", KeyNodeInfoMetadata, err)
+		}
+	}
+
 	return conf, nil
 }
 
+// splitList returns the non-empty elements of a comma or space separated list, or nil when there are none.
+func splitList(s string) []string {
+	l := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
+
+// splitListOrNone works like splitList, but it returns an empty, non-nil, list for the "none" value,
+// which allows disabling the values that have a default otherwise.
+func splitListOrNone(s string) []string {
+	if strings.EqualFold(strings.TrimSpace(s), "none") {
+		return []string{}
+	}
+	return splitList(s)
+}
+
 func normalizeStoragePath(p string, o StorageConfig, env Env) string {
 	if len(p) == 0 {
 		return p
@@ -13,6 +13,12 @@ const (
 	secure   = true
 	listen   = "127.0.0.3:666"
 	pgSQL    = "postgres"
+
+	softwareName  = "brutalinks"
+	staffAccounts = "https://testing.git/actors/admin, https://testing.git/actors/mod"
+	metadata      = `{"nodeAdmins":["admin"]}`
+	rules         = `["Be excellent to each other", "No spam, please"]`
+	issuers       = `{"https://testing.git/actors/app":{"grant_types":["client_credentials"],"code_challenge_methods":[]}}`
 )
 
 func TestLoadFromEnv(t *testing.T) {
@@ -22,6 +28,15 @@ func TestLoadFromEnv(t *testing.T) {
 		os.Setenv(KeyHTTPS, fmt.Sprintf("%t", secure))
 		os.Setenv(KeyListen, listen)
 		os.Setenv(KeyStorage, pgSQL)
+		os.Setenv(KeyOpenRegistrations, "false")
+		os.Setenv(KeyInstanceRules, rules)
+		os.Setenv(KeyNodeInfoSoftwareName, softwareName)
+		os.Setenv(KeyNodeInfoPrivate, "true")
+		os.Setenv(KeyNodeInfoStaffAccounts, staffAccounts)
+		os.Setenv(KeyNodeInfoMetadata, metadata)
+		os.Setenv(KeyOAuthCodeChallengeMethods, "none")
+		os.Setenv(KeyOAuthIssuers, issuers)
+		os.Setenv(KeyWebFingerSearch, "true")
 
 		c, err := LoadFromEnv(TEST, time.Second)
 		if err != nil {
@@ -37,5 +52,51 @@ func TestLoadFromEnv(t *testing.T) {
 		if c.Listen != listen {
 			t.Errorf("Invalid loaded value for %s: %s, expected %s", KeyListen, c.Listen, listen)
 		}
+		if c.OpenRegistrations == nil || *c.OpenRegistrations {
+			t.Errorf("Invalid loaded value for %s: %v, expected %t", KeyOpenRegistrations, c.OpenRegistrations, false)
+		}
+		if len(c.Instance.Rules) != 2 {
+			t.Errorf("Invalid loaded value for %s: %v, expected 2 rules", KeyInstanceRules, c.Instance.Rules)
+		}
+		if c.NodeInfo.SoftwareName != softwareName {
+			t.Errorf("Invalid loaded value for %s: %s, expected %s", KeyNodeInfoSoftwareName, c.NodeInfo.SoftwareName, softwareName)
+		}
+		if !c.NodeInfo.Private {
+			t.Errorf("Invalid loaded value for %s: %t, expected %t", KeyNodeInfoPrivate, c.NodeInfo.Private, true)
+		}
+		if len(c.NodeInfo.StaffAccounts) != 2 {
+			t.Errorf("Invalid loaded value for %s: %v, expected 2 accounts", KeyNodeInfoStaffAccounts, c.NodeInfo.StaffAccounts)
+		}
+		if _, ok := c.NodeInfo.Metadata["nodeAdmins"]; !ok {
+			t.Errorf("Invalid loaded value for %s: %v, expected nodeAdmins key", KeyNodeInfoMetadata, c.NodeInfo.Metadata)
+		}
+		if !c.WebFinger.Search {
+			t.Errorf("Invalid loaded value for %s: %t, expected %t", KeyWebFingerSearch, c.WebFinger.Search, true)
+		}
+		if c.OAuth.CodeChallengeMethods == nil || len(c.OAuth.CodeChallengeMethods) != 0 {
+			t.Errorf("Invalid loaded value for %s: %v, expected an empty list", KeyOAuthCodeChallengeMethods, c.OAuth.CodeChallengeMethods)
+		}
+		app, ok := c.OAuth.Issuers["https://testing.git/actors/app"]
+		if !ok {
+			t.Fatalf("Invalid loaded value for %s: %v, expected the app issuer", KeyOAuthIssuers, c.OAuth.Issuers)
+		}
+		if len(app.GrantTypes) != 1 || app.GrantTypes[0] != "client_credentials" {
+			t.Errorf("Invalid loaded value for %s: %v, expected the client_credentials grant", KeyOAuthIssuers, app.GrantTypes)
+		}
+		if app.CodeChallengeMethods == nil || len(app.CodeChallengeMethods) != 0 {
+			t.Errorf("Invalid loaded value for %s: %v, expected an empty list", KeyOAuthIssuers, app.CodeChallengeMethods)
+		}
+	}
+}
+
+func TestSplitListOrNone(t *testing.T) {
+	if l := splitListOrNone(""); l != nil {
+		t.Errorf("Invalid list %v for empty value, expected nil", l)
+	}
+	if l := splitListOrNone("none"); l == nil || len(l) != 0 {
+		t.Errorf("Invalid list %v for none value, expected an empty list", l)
+	}
+	if l := splitListOrNone("S256, plain"); len(l) != 2 {
+		t.Errorf("Invalid list %v, expected 2 elements", l)
 	}
 }
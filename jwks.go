@@ -0,0 +1,157 @@
+package webfinger
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strings"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// JWK is a JSON Web Key, as described in RFC7517.
+//
+// https://datatracker.ietf.org/doc/html/rfc7517#section-4
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// JWKSet is a JSON Web Key Set, as described in RFC7517.
+//
+// https://datatracker.ietf.org/doc/html/rfc7517#section-5
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// thumbprint returns the RFC7638 thumbprint of the JWK, which only depends on its required members.
+func (k JWK) thumbprint() string {
+	var members string
+	switch k.Kty {
+	case "RSA":
+		members = `{"e":"` + k.E + `","kty":"RSA","n":"` + k.N + `"}`
+	case "EC":
+		members = `{"crv":"` + k.Crv + `","kty":"EC","x":"` + k.X + `","y":"` + k.Y + `"}`
+	case "OKP":
+		members = `{"crv":"` + k.Crv + `","kty":"OKP","x":"` + k.X + `"}`
+	}
+	sum := sha256.Sum256([]byte(members))
+	return b64(sum[:])
+}
+
+// JWKOf converts a public key to a JWK.
+//
+// The "kid" is the IRI of the key when it has one, and the RFC7638 thumbprint otherwise,
+// so it doesn't change between requests.
+func JWKOf(id vocab.IRI, pub crypto.PublicKey) (JWK, error) {
+	k := JWK{Use: "sig", Alg: signingAlgOf(pub)}
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		k.Kty = "RSA"
+		k.N = b64(key.N.Bytes())
+		k.E = b64(big.NewInt(int64(key.E)).Bytes())
+	case ed25519.PublicKey:
+		k.Kty = "OKP"
+		k.Crv = "Ed25519"
+		k.X = b64(key)
+	case *ecdsa.PublicKey:
+		ecdhKey, err := key.ECDH()
+		if err != nil {
+			return k, errors.Annotatef(err, "invalid EC public key")
+		}
+		// NOTE(marius): the uncompressed point is 0x04 || x || y
+		point := ecdhKey.Bytes()[1:]
+		k.Kty = "EC"
+		k.Crv = key.Curve.Params().Name
+		k.X = b64(point[:len(point)/2])
+		k.Y = b64(point[len(point)/2:])
+	default:
+		return k, errors.Newf("unsupported public key type %T", pub)
+	}
+	k.Kid = string(id)
+	if k.Kid == "" {
+		k.Kid = k.thumbprint()
+	}
+	return k, nil
+}
+
+// JWKSetOf returns the JSON Web Key Set containing the valid keys of the actor.
+func JWKSetOf(actor vocab.Actor) JWKSet {
+	set := JWKSet{Keys: make([]JWK, 0)}
+	for _, k := range KeysOf(actor) {
+		jwk, err := JWKOf(k.ID, k.Key)
+		if err != nil {
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// with returns the set with the key appended, if it doesn't already contain a key with the same kid.
+func (s JWKSet) with(k JWK) JWKSet {
+	for _, kk := range s.Keys {
+		if kk.Kid == k.Kid {
+			return s
+		}
+	}
+	s.Keys = append(s.Keys, k)
+	return s
+}
+
+const WellKnownJWKSPath = "/.well-known/jwks.json"
+
+// jwksIRI returns the IRI where we serve the JWKS of the self actor, which follows
+// the same convention as the RFC8414 metadata, with the issuer path appended to the well known one.
+func jwksIRI(self vocab.Actor) string {
+	u, err := self.ID.URL()
+	if err != nil {
+		return ""
+	}
+	u.Path = WellKnownJWKSPath + strings.TrimRight(u.Path, "/")
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
+
+// HandleJWKS serves /.well-known/jwks.json
+func (h handler) HandleJWKS(w http.ResponseWriter, r *http.Request) {
+	self, storage, err := h.loadIssuer(r, WellKnownJWKSPath)
+	if err != nil {
+		handleErr(h.l)(r, err).ServeHTTP(w, r)
+		return
+	}
+	set := JWKSetOf(*self)
+	// NOTE(marius): the key signing the signed_metadata needs to be published, so the clients can verify it
+	if key, kid, err := metadataSigningKey(storage, storage.Options.OAuth.ForIssuer(self.ID)); err == nil {
+		if jwk, err := JWKOf(vocab.IRI(kid), key.Public()); err == nil {
+			set = set.with(jwk)
+		}
+	} else if !errors.IsNotFound(err) {
+		h.l.Warnf("Unable to load the OAuth2 authorization server metadata signing key: %s", err)
+	}
+	data, _ := json.Marshal(set)
+
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+	h.l.Debugf("%s %s%s %d %s", r.Method, r.Host, r.RequestURI, http.StatusOK, http.StatusText(http.StatusOK))
+}
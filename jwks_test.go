@@ -0,0 +1,121 @@
+package webfinger
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"git.sr.ht/~mariusor/lw"
+	vocab "github.com/go-ap/activitypub"
+)
+
+func pemEncodePublicKey(t *testing.T, pub any) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatalf("unable to marshal public key: %s", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func TestJWKSetOf(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unable to generate RSA key: %s", err)
+	}
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate Ed25519 key: %s", err)
+	}
+
+	tests := []struct {
+		name    string
+		pub     any
+		wantKty string
+		wantAlg string
+	}{
+		{name: "RSA", pub: &rsaKey.PublicKey, wantKty: "RSA", wantAlg: "RS256"},
+		{name: "Ed25519", pub: edPub, wantKty: "OKP", wantAlg: "EdDSA"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actor := vocab.Actor{
+				ID: "https://example.com/actors/jdoe",
+				PublicKey: vocab.PublicKey{
+					ID:           "https://example.com/actors/jdoe#main",
+					Owner:        "https://example.com/actors/jdoe",
+					PublicKeyPem: pemEncodePublicKey(t, tt.pub),
+				},
+			}
+			set := JWKSetOf(actor)
+			if len(set.Keys) != 1 {
+				t.Fatalf("Invalid number of keys %d, expected 1", len(set.Keys))
+			}
+			k := set.Keys[0]
+			if k.Kty != tt.wantKty || k.Alg != tt.wantAlg {
+				t.Errorf("Invalid key type %s/%s, expected %s/%s", k.Kty, k.Alg, tt.wantKty, tt.wantAlg)
+			}
+			if k.Kid != "https://example.com/actors/jdoe#main" {
+				t.Errorf("Invalid kid %s, expected the public key IRI", k.Kid)
+			}
+		})
+	}
+}
+
+func TestJWKSetOf_noKeys(t *testing.T) {
+	set := JWKSetOf(vocab.Actor{ID: "https://example.com/actors/jdoe"})
+	if set.Keys == nil || len(set.Keys) != 0 {
+		t.Errorf("Expected an empty key list, got %v", set.Keys)
+	}
+}
+
+func TestJWKOf_stableKid(t *testing.T) {
+	edPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	k1, _ := JWKOf("", edPub)
+	k2, _ := JWKOf("", edPub)
+	if k1.Kid == "" || k1.Kid != k2.Kid {
+		t.Errorf("Expected a stable thumbprint kid, got %q and %q", k1.Kid, k2.Kid)
+	}
+}
+
+func TestJWKsIRI(t *testing.T) {
+	tests := map[vocab.IRI]string{
+		"https://example.com":             "https://example.com/.well-known/jwks.json",
+		"https://example.com/":            "https://example.com/.well-known/jwks.json",
+		"https://example.com/actors/jdoe": "https://example.com/.well-known/jwks.json/actors/jdoe",
+	}
+	for id, want := range tests {
+		if got := jwksIRI(vocab.Actor{ID: id}); got != want {
+			t.Errorf("jwksIRI(%s) = %s, want %s", id, got, want)
+		}
+	}
+}
+
+func TestHandler_HandleJWKS_signingKey(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	root := itemFromJSON(t, `{"id":"https://example.com","type":"Application"}`)
+	o := Options{OAuth: OAuthOptions{SignMetadata: true, SigningKey: key}}
+	h := handler{s: []Store{WithOptions(newMockStore(root), o)}, l: lw.Dev(lw.SetLevel(lw.ErrorLevel))}
+
+	r := httptest.NewRequest(http.MethodGet, WellKnownJWKSPath, nil)
+	r.Host = "example.com"
+	w := httptest.NewRecorder()
+	h.HandleJWKS(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("HandleJWKS() status %d, expected %d", w.Code, http.StatusOK)
+	}
+	set := JWKSet{}
+	if err := json.Unmarshal(w.Body.Bytes(), &set); err != nil {
+		t.Fatalf("invalid JWKS %s: %s", w.Body.Bytes(), err)
+	}
+	want, _ := JWKOf("", key.Public())
+	if len(set.Keys) != 1 || set.Keys[0] != want {
+		t.Errorf("Invalid JWKS keys %v, expected the signing key %v", set.Keys, want)
+	}
+}
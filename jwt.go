@@ -0,0 +1,155 @@
+package webfinger
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+
+	"github.com/go-ap/errors"
+)
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+func hashFor(alg string) (crypto.Hash, error) {
+	switch alg {
+	case "RS256", "ES256":
+		return crypto.SHA256, nil
+	case "ES384":
+		return crypto.SHA384, nil
+	case "ES512":
+		return crypto.SHA512, nil
+	case "EdDSA":
+		return 0, nil
+	}
+	return 0, errors.Newf("unsupported JWT algorithm %q", alg)
+}
+
+func digest(h crypto.Hash, data []byte) []byte {
+	switch h {
+	case crypto.SHA256:
+		sum := sha256.Sum256(data)
+		return sum[:]
+	case crypto.SHA384:
+		sum := sha512.Sum384(data)
+		return sum[:]
+	case crypto.SHA512:
+		sum := sha512.Sum512(data)
+		return sum[:]
+	}
+	return data
+}
+
+func ecdsaKeySize(pub *ecdsa.PublicKey) int {
+	return (pub.Curve.Params().BitSize + 7) / 8
+}
+
+// signJWT returns a compact serialized JWS of the claims, signed with the key.
+func signJWT(claims any, key crypto.Signer, kid string) (string, error) {
+	alg := signingAlgOf(key.Public())
+	h, err := hashFor(alg)
+	if err != nil {
+		return "", err
+	}
+
+	header, err := json.Marshal(jwtHeader{Alg: alg, Typ: "JWT", Kid: kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := b64(header) + "." + b64(payload)
+
+	var sig []byte
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signingInput))
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest(h, []byte(signingInput)))
+		if err != nil {
+			return "", err
+		}
+		// NOTE(marius): JWS uses the fixed size R || S encoding of the ECDSA signatures, not the ASN.1 one
+		size := ecdsaKeySize(&k.PublicKey)
+		sig = make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+	default:
+		sig, err = key.Sign(rand.Reader, digest(h, []byte(signingInput)), h)
+		if err != nil {
+			return "", err
+		}
+	}
+	return signingInput + "." + b64(sig), nil
+}
+
+// verifyJWT checks the signature of the compact serialized JWS token with the public key,
+// and returns its decoded payload.
+func verifyJWT(token string, pub crypto.PublicKey) ([]byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.Newf("invalid JWT format")
+	}
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid JWT header encoding")
+	}
+	header := jwtHeader{}
+	if err = json.Unmarshal(rawHeader, &header); err != nil {
+		return nil, errors.Annotatef(err, "invalid JWT header")
+	}
+	if alg := signingAlgOf(pub); header.Alg != alg {
+		return nil, errors.Newf("JWT algorithm %q doesn't match the key algorithm %q", header.Alg, alg)
+	}
+	h, err := hashFor(header.Alg)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid JWT signature encoding")
+	}
+
+	signingInput := []byte(parts[0] + "." + parts[1])
+	switch k := pub.(type) {
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, signingInput, sig) {
+			return nil, errors.Newf("invalid JWT signature")
+		}
+	case *rsa.PublicKey:
+		if err = rsa.VerifyPKCS1v15(k, h, digest(h, signingInput), sig); err != nil {
+			return nil, errors.Annotatef(err, "invalid JWT signature")
+		}
+	case *ecdsa.PublicKey:
+		size := ecdsaKeySize(k)
+		if len(sig) != 2*size {
+			return nil, errors.Newf("invalid JWT signature length")
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest(h, signingInput), r, s) {
+			return nil, errors.Newf("invalid JWT signature")
+		}
+	default:
+		return nil, errors.Newf("unsupported public key type %T", pub)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid JWT payload encoding")
+	}
+	return bytes.TrimSpace(payload), nil
+}
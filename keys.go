@@ -0,0 +1,288 @@
+package webfinger
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"strings"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// publicKeyFromPEM decodes a PEM encoded public key, as found in the ActivityPub actors' publicKey property.
+func publicKeyFromPEM(data string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.Newf("invalid PEM encoded public key")
+	}
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	}
+}
+
+// privateKeyFromPEM decodes a PKCS#1, SEC 1 or PKCS#8 PEM encoded private key that can be used for signing.
+func privateKeyFromPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.Newf("invalid PEM encoded private key")
+	}
+	var (
+		key any
+		err error
+	)
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.Newf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
+// publicKeyOf returns the public key of the actor.
+func publicKeyOf(actor vocab.Actor) (crypto.PublicKey, error) {
+	if actor.PublicKey.PublicKeyPem == "" {
+		return nil, errors.NotFoundf("actor %s has no public key", actor.ID)
+	}
+	return publicKeyFromPEM(actor.PublicKey.PublicKeyPem)
+}
+
+// signingAlgOf returns the JWA signing algorithm that corresponds to the public key.
+func signingAlgOf(pub crypto.PublicKey) string {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return "RS256"
+	case ed25519.PublicKey:
+		return "EdDSA"
+	case *ecdsa.PublicKey:
+		switch k.Curve.Params().BitSize {
+		case 384:
+			return "ES384"
+		case 521:
+			return "ES512"
+		default:
+			return "ES256"
+		}
+	}
+	return ""
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// decodeBase58 decodes a string using the bitcoin base58 alphabet.
+func decodeBase58(s string) ([]byte, error) {
+	result := make([]byte, 0, len(s))
+	for _, c := range []byte(s) {
+		carry := strings.IndexByte(base58Alphabet, c)
+		if carry < 0 {
+			return nil, errors.Newf("invalid base58 character %q", c)
+		}
+		for i := len(result) - 1; i >= 0; i-- {
+			carry += int(result[i]) * 58
+			result[i] = byte(carry & 0xff)
+			carry >>= 8
+		}
+		for carry > 0 {
+			result = append([]byte{byte(carry & 0xff)}, result...)
+			carry >>= 8
+		}
+	}
+	// NOTE(marius): every leading '1' is a leading zero byte
+	for i := 0; i < len(s) && s[i] == '1'; i++ {
+		result = append([]byte{0}, result...)
+	}
+	return result, nil
+}
+
+// encodeBase58 encodes the data using the bitcoin base58 alphabet.
+func encodeBase58(data []byte) string {
+	digits := make([]byte, 0, len(data)*138/100+1)
+	for _, b := range data {
+		carry := int(b)
+		for i := range digits {
+			carry += int(digits[i]) << 8
+			digits[i] = byte(carry % 58)
+			carry /= 58
+		}
+		for carry > 0 {
+			digits = append(digits, byte(carry%58))
+			carry /= 58
+		}
+	}
+	res := make([]byte, 0, len(digits)+len(data))
+	// NOTE(marius): every leading zero byte is a leading '1'
+	for i := 0; i < len(data) && data[i] == 0; i++ {
+		res = append(res, base58Alphabet[0])
+	}
+	for i := len(digits) - 1; i >= 0; i-- {
+		res = append(res, base58Alphabet[digits[i]])
+	}
+	return string(res)
+}
+
+var (
+	multicodecEd25519Pub = []byte{0xed, 0x01}
+	multicodecRSAPub     = []byte{0x85, 0x24}
+)
+
+// publicKeyFromMultibase decodes the publicKeyMultibase value of a Multikey.
+//
+// Only the base58-btc multibase encoding, and Ed25519 and RSA keys are supported.
+//
+// https://www.w3.org/TR/cid-1.0/#Multikey
+func publicKeyFromMultibase(mb string) (crypto.PublicKey, error) {
+	if len(mb) < 2 || mb[0] != 'z' {
+		return nil, errors.Newf("unsupported multibase encoding")
+	}
+	raw, err := decodeBase58(mb[1:])
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(raw, multicodecEd25519Pub):
+		key := raw[len(multicodecEd25519Pub):]
+		if len(key) != ed25519.PublicKeySize {
+			return nil, errors.Newf("invalid Ed25519 public key length %d", len(key))
+		}
+		return ed25519.PublicKey(key), nil
+	case bytes.HasPrefix(raw, multicodecRSAPub):
+		return x509.ParsePKCS1PublicKey(raw[len(multicodecRSAPub):])
+	}
+	return nil, errors.Newf("unsupported multikey type")
+}
+
+// multibaseOf encodes the public key as the publicKeyMultibase value of a Multikey,
+// it is the reverse of publicKeyFromMultibase.
+func multibaseOf(pub crypto.PublicKey) (string, error) {
+	var raw []byte
+	switch key := pub.(type) {
+	case ed25519.PublicKey:
+		raw = append(append(raw, multicodecEd25519Pub...), key...)
+	case *rsa.PublicKey:
+		raw = append(append(raw, multicodecRSAPub...), x509.MarshalPKCS1PublicKey(key)...)
+	default:
+		return "", errors.Newf("unsupported multikey type %T", pub)
+	}
+	return "z" + encodeBase58(raw), nil
+}
+
+// ActorKey is a public key of an actor, together with the IRI that identifies it.
+type ActorKey struct {
+	ID  vocab.IRI
+	Key crypto.PublicKey
+}
+
+var multikeyType = vocab.ActivityVocabularyType("Multikey")
+
+// Multikey is a FEP-521a public key, as found in the assertionMethod property of the actors.
+//
+// https://codeberg.org/fediverse/fep/src/branch/main/fep/521a/fep-521a.md
+type Multikey struct {
+	ID                 vocab.IRI  `json:"id"`
+	Type               string     `json:"type"`
+	Controller         vocab.IRI  `json:"controller"`
+	PublicKeyMultibase string     `json:"publicKeyMultibase"`
+	Expires            *time.Time `json:"expires,omitempty"`
+	Revoked            *time.Time `json:"revoked,omitempty"`
+}
+
+// AssertionMethods is the value of the assertionMethod property, which can be a single Multikey or a list.
+// The references to keys by their IRI are skipped, as they don't contain the key material.
+type AssertionMethods []Multikey
+
+func (a *AssertionMethods) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	raw := make([]json.RawMessage, 0)
+	if len(data) > 0 && data[0] == '[' {
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+	} else {
+		raw = append(raw, data)
+	}
+	for _, r := range raw {
+		if r = bytes.TrimSpace(r); len(r) == 0 || r[0] != '{' {
+			continue
+		}
+		k := Multikey{}
+		if err := json.Unmarshal(r, &k); err != nil {
+			return err
+		}
+		*a = append(*a, k)
+	}
+	return nil
+}
+
+// Keys returns the multikeys which are controlled by the actor, and are not expired or revoked.
+func (a AssertionMethods) Keys(controller vocab.IRI, now time.Time) []ActorKey {
+	keys := make([]ActorKey, 0, len(a))
+	for _, k := range a {
+		if k.Type != string(multikeyType) || (k.Controller != "" && !k.Controller.Equals(controller, false)) {
+			continue
+		}
+		if (k.Expires != nil && k.Expires.Before(now)) || (k.Revoked != nil && !k.Revoked.After(now)) {
+			continue
+		}
+		pub, err := publicKeyFromMultibase(strings.TrimSpace(k.PublicKeyMultibase))
+		if err != nil {
+			continue
+		}
+		keys = append(keys, ActorKey{ID: k.ID, Key: pub})
+	}
+	return keys
+}
+
+// assertionMethodsOf returns the assertionMethod property of the actor, as found in its JSON document.
+func assertionMethodsOf(actor vocab.Actor) AssertionMethods {
+	raw, err := vocab.MarshalJSON(actor)
+	if err != nil {
+		return nil
+	}
+	doc := struct {
+		AssertionMethods AssertionMethods `json:"assertionMethod"`
+	}{}
+	_ = json.Unmarshal(raw, &doc)
+	return doc.AssertionMethods
+}
+
+// multikeysOf returns the FEP-521a multikeys of the actor which are not expired or revoked.
+//
+// NOTE(marius): the keys are read from the assertionMethod property of the actor's document, so they are
+// available only as long as the vocabulary package keeps the property when decoding the actors from the storage.
+// Until then, the local actors expose only the key from their publicKey property.
+func multikeysOf(actor vocab.Actor, now time.Time) []ActorKey {
+	return assertionMethodsOf(actor).Keys(actor.ID, now)
+}
+
+// KeysOf returns the valid public keys of the actor: the one in its publicKey property and
+// the FEP-521a multikeys.
+func KeysOf(actor vocab.Actor) []ActorKey {
+	keys := make([]ActorKey, 0)
+	if pub, err := publicKeyOf(actor); err == nil {
+		id := actor.PublicKey.ID
+		if id == "" {
+			id = actor.ID
+		}
+		keys = append(keys, ActorKey{ID: id, Key: pub})
+	}
+	return append(keys, multikeysOf(actor, time.Now())...)
+}
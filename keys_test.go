@@ -0,0 +1,97 @@
+package webfinger
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+// NOTE(marius): the Ed25519 example key from the did:key specification
+const testMultibaseKey = "z6MkrJVnaZkeFzdQyMZu1cgjg7k1pZZ6pvBQ7XJPt4swbTQ2"
+
+func TestPublicKeyFromMultibase(t *testing.T) {
+	pub, err := publicKeyFromMultibase(testMultibaseKey)
+	if err != nil {
+		t.Fatalf("unable to decode multikey: %s", err)
+	}
+	if _, ok := pub.(ed25519.PublicKey); !ok {
+		t.Errorf("Invalid key type %T, expected ed25519.PublicKey", pub)
+	}
+	if _, err = publicKeyFromMultibase("uAAAA"); err == nil {
+		t.Errorf("Expected error for unsupported multibase encoding")
+	}
+}
+
+func TestAssertionMethods_Keys(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		value string
+		want  []vocab.IRI
+	}{
+		{
+			name:  "single object",
+			value: `{"id":"https://example.com/actors/jdoe#ed25519","type":"Multikey","controller":"https://example.com/actors/jdoe","publicKeyMultibase":"` + testMultibaseKey + `"}`,
+			want:  []vocab.IRI{"https://example.com/actors/jdoe#ed25519"},
+		},
+		{
+			name: "list with references and invalid keys",
+			value: `["https://example.com/actors/jdoe#ref",
+				{"id":"https://example.com/actors/jdoe#1","type":"Multikey","controller":"https://example.com/actors/jdoe","publicKeyMultibase":"` + testMultibaseKey + `"},
+				{"id":"https://example.com/actors/jdoe#2","type":"Multikey","controller":"https://example.com/actors/jdoe","publicKeyMultibase":"uAAAA"},
+				{"id":"https://example.com/actors/jdoe#3","type":"JsonWebKey","controller":"https://example.com/actors/jdoe","publicKeyMultibase":"` + testMultibaseKey + `"}]`,
+			want: []vocab.IRI{"https://example.com/actors/jdoe#1"},
+		},
+		{
+			name:  "other controller",
+			value: `{"id":"https://example.org/actors/eve#1","type":"Multikey","controller":"https://example.org/actors/eve","publicKeyMultibase":"` + testMultibaseKey + `"}`,
+			want:  []vocab.IRI{},
+		},
+		{
+			name: "expired and revoked",
+			value: `[{"id":"https://example.com/actors/jdoe#1","type":"Multikey","publicKeyMultibase":"` + testMultibaseKey + `","expires":"2025-01-01T00:00:00Z"},
+				{"id":"https://example.com/actors/jdoe#2","type":"Multikey","publicKeyMultibase":"` + testMultibaseKey + `","revoked":"2025-06-01T00:00:00Z"},
+				{"id":"https://example.com/actors/jdoe#3","type":"Multikey","publicKeyMultibase":"` + testMultibaseKey + `","expires":"2027-01-01T00:00:00Z"}]`,
+			want: []vocab.IRI{"https://example.com/actors/jdoe#3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			methods := AssertionMethods{}
+			if err := json.Unmarshal([]byte(tt.value), &methods); err != nil {
+				t.Fatalf("unable to unmarshal assertionMethod: %s", err)
+			}
+			keys := methods.Keys("https://example.com/actors/jdoe", now)
+			if len(keys) != len(tt.want) {
+				t.Fatalf("Invalid keys %v, expected %v", keys, tt.want)
+			}
+			for i, k := range keys {
+				if k.ID != tt.want[i] {
+					t.Errorf("Invalid key id %s, expected %s", k.ID, tt.want[i])
+				}
+				if _, ok := k.Key.(ed25519.PublicKey); !ok {
+					t.Errorf("Invalid key type %T, expected ed25519.PublicKey", k.Key)
+				}
+			}
+		})
+	}
+}
+
+func TestMultikeysOf(t *testing.T) {
+	it, err := vocab.UnmarshalJSON([]byte(`{"id":"https://example.com/actors/jdoe","type":"Person","attachment":[
+		{"id":"https://example.com/actors/jdoe#ed25519","type":"Multikey","content":"` + testMultibaseKey + `"},
+		{"type":"Note","name":"Website","content":"https://example.com"}]}`))
+	if err != nil {
+		t.Fatalf("unable to unmarshal actor: %s", err)
+	}
+	act, err := vocab.ToActor(it)
+	if err != nil {
+		t.Fatalf("invalid actor: %s", err)
+	}
+	if keys := multikeysOf(*act, time.Now()); len(keys) != 0 {
+		t.Errorf("Invalid multikeys %v, the attachments should not be used as keys", keys)
+	}
+}
@@ -1,22 +1,212 @@
 package webfinger
 
 import (
+	"encoding/json"
+	"html"
 	"net/http"
 	"path"
 	"regexp"
+	"strings"
 
 	"git.sr.ht/~mariusor/storage-all"
 	vocab "github.com/go-ap/activitypub"
 	"github.com/go-ap/auth"
 	"github.com/go-ap/errors"
 	"github.com/go-ap/filters"
-	"github.com/writeas/go-nodeinfo"
 )
 
+type (
+	NodeProtocol string
+	NodeService  string
+)
+
+const (
+	// Protocols that can be supported by this node.
+	ProtocolActivityPub NodeProtocol = "activitypub"
+	ProtocolBuddyCloud  NodeProtocol = "buddycloud"
+	ProtocolDFRN        NodeProtocol = "dfrn"
+	ProtocolDiaspora    NodeProtocol = "diaspora"
+	ProtocolLibertree   NodeProtocol = "libertree"
+	ProtocolOStatus     NodeProtocol = "ostatus"
+	ProtocolPumpIO      NodeProtocol = "pumpio"
+	ProtocolTent        NodeProtocol = "tent"
+	ProtocolXMPP        NodeProtocol = "xmpp"
+	ProtocolZot         NodeProtocol = "zot"
+
+	// Services that can be supported (inbound or outbound) by this node's API.
+	ServiceAtom      NodeService = "atom1.0"
+	ServiceGNUSocial NodeService = "gnusocial"
+	ServiceIMAP      NodeService = "imap"
+	ServicePnut      NodeService = "pnut"
+	ServicePOP3      NodeService = "pop3"
+	ServicePumpIO    NodeService = "pumpio"
+	ServiceRSS       NodeService = "rss2.0"
+	ServiceSMTP      NodeService = "smtp"
+	ServiceTwitter   NodeService = "twitter"
+	ServiceTumblr    NodeService = "tumblr"
+	ServiceXMPP      NodeService = "xmpp"
+)
+
+var (
+	// ValidProtocols are the protocols accepted by the NodeInfo schemas.
+	ValidProtocols = []NodeProtocol{
+		ProtocolActivityPub, ProtocolBuddyCloud, ProtocolDFRN, ProtocolDiaspora, ProtocolLibertree,
+		ProtocolOStatus, ProtocolPumpIO, ProtocolTent, ProtocolXMPP, ProtocolZot,
+	}
+	// ValidInboundServices are the services accepted by the NodeInfo schemas for the inbound list.
+	ValidInboundServices = []NodeService{
+		ServiceAtom, ServiceGNUSocial, ServiceIMAP, ServicePnut, ServicePOP3, ServicePumpIO, ServiceRSS, ServiceTwitter,
+	}
+	// ValidOutboundServices are the services accepted by the NodeInfo schemas for the outbound list.
+	ValidOutboundServices = []NodeService{
+		ServiceAtom, "blogger", "buddycloud", "diaspora", "dreamwidth", "drupal", "facebook", "friendica",
+		ServiceGNUSocial, "google", "insanejournal", "libertree", "linkedin", "livejournal", "mediagoblin",
+		"myspace", "pinterest", ServicePnut, "posterous", ServicePumpIO, "redmatrix", ServiceRSS, ServiceSMTP,
+		"tent", ServiceTumblr, ServiceTwitter, "wordpress", ServiceXMPP,
+	}
+)
+
+func validService(valid []NodeService, s NodeService) bool {
+	for _, v := range valid {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func appendService(services []NodeService, s NodeService) []NodeService {
+	for _, ss := range services {
+		if ss == s {
+			return services
+		}
+	}
+	return append(services, s)
+}
+
+const (
+	NodeInfoVersion20 = "2.0"
+	NodeInfoVersion21 = "2.1"
+	NodeInfoVersion22 = "2.2"
+
+	nodeInfoProfileBase = "http://nodeinfo.diaspora.software/ns/schema/"
+)
+
+// NodeInfoVersions lists the NodeInfo schema versions we can serve, in the order they get advertised.
+var NodeInfoVersions = []string{NodeInfoVersion20, NodeInfoVersion21, NodeInfoVersion22}
+
+func nodeInfoProfile(version string) string {
+	return nodeInfoProfileBase + version
+}
+
+func validNodeInfoVersion(version string) bool {
+	for _, v := range NodeInfoVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+type (
+	// NodeInfo is the document served at the NodeInfo end-points.
+	//
+	// It contains the union of the properties of the 2.0, 2.1 and 2.2 schemas, the ones that are not part
+	// of a specific version are removed by ForVersion.
+	//
+	// http://nodeinfo.diaspora.software/schema.html
+	NodeInfo struct {
+		Version           string            `json:"version"`
+		Instance          *NodeInfoInstance `json:"instance,omitempty"`
+		Software          NodeInfoSoftware  `json:"software"`
+		Protocols         []NodeProtocol    `json:"protocols"`
+		Services          NodeInfoServices  `json:"services"`
+		OpenRegistrations bool              `json:"openRegistrations"`
+		Usage             NodeInfoUsage     `json:"usage"`
+		Metadata          NodeInfoMetadata  `json:"metadata"`
+	}
+
+	// NodeInfoInstance was added in version 2.2 of the schema.
+	NodeInfoInstance struct {
+		Name        string `json:"name,omitempty"`
+		Description string `json:"description,omitempty"`
+	}
+
+	NodeInfoSoftware struct {
+		// Name (canonical) of this server software.
+		Name string `json:"name"`
+		// Version of this server software.
+		Version string `json:"version"`
+		// Repository is the URL of the source code repository, added in version 2.1 of the schema.
+		Repository string `json:"repository,omitempty"`
+		// Homepage is the URL of the homepage of the server software, added in version 2.1 of the schema.
+		Homepage string `json:"homepage,omitempty"`
+	}
+
+	NodeInfoServices struct {
+		Inbound  []NodeService `json:"inbound"`
+		Outbound []NodeService `json:"outbound"`
+	}
+
+	// NodeInfoUsage is usage statistics for this server.
+	NodeInfoUsage struct {
+		Users         NodeInfoUsers `json:"users"`
+		LocalPosts    int           `json:"localPosts,omitempty"`
+		LocalComments int           `json:"localComments,omitempty"`
+	}
+
+	NodeInfoUsers struct {
+		Total          int `json:"total"`
+		ActiveHalfYear int `json:"activeHalfyear,omitempty"`
+		ActiveMonth    int `json:"activeMonth,omitempty"`
+		// ActiveWeek was added in version 2.2 of the schema.
+		ActiveWeek int `json:"activeWeek,omitempty"`
+	}
+
+	// NodeInfoMetadata contains free form key value pairs for software specific values.
+	NodeInfoMetadata map[string]any
+)
+
+var invalidSoftwareNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// ForVersion returns a copy of the NodeInfo document that contains only the properties valid
+// for the received schema version.
+func (n NodeInfo) ForVersion(version string) NodeInfo {
+	ni := n
+	ni.Version = version
+	// NOTE(marius): all schema versions require the software name to match ^[a-z0-9-]+$
+	ni.Software.Name = strings.Trim(invalidSoftwareNameChars.ReplaceAllString(strings.ToLower(n.Software.Name), "-"), "-")
+	if len(ni.Protocols) == 0 {
+		// NOTE(marius): the schemas require at least one protocol
+		ni.Protocols = []NodeProtocol{ProtocolActivityPub}
+	}
+	if ni.Services.Inbound == nil {
+		ni.Services.Inbound = []NodeService{}
+	}
+	if ni.Services.Outbound == nil {
+		ni.Services.Outbound = []NodeService{}
+	}
+	if ni.Metadata == nil {
+		ni.Metadata = NodeInfoMetadata{}
+	}
+	switch version {
+	case NodeInfoVersion20:
+		ni.Software.Repository = ""
+		ni.Software.Homepage = ""
+		fallthrough
+	case NodeInfoVersion21:
+		ni.Instance = nil
+		ni.Usage.Users.ActiveWeek = 0
+	}
+	return ni
+}
+
 type NodeInfoResolver struct {
 	users    int
 	comments int
 	posts    int
+
+	openRegistrations bool
 }
 
 var (
@@ -84,13 +274,94 @@ func NodeInfoResolverNew(r storage.ReadStore, app vocab.Actor) NodeInfoResolver
 }
 
 func (n NodeInfoResolver) IsOpenRegistration() (bool, error) {
-	// TODO(marius)
-	return true, nil
+	return n.openRegistrations, nil
+}
+
+// itemsOf returns the items of a property that can be either a single item or a collection of them.
+func itemsOf(it vocab.Item) vocab.ItemCollection {
+	items := make(vocab.ItemCollection, 0)
+	if vocab.IsNil(it) {
+		return items
+	}
+	if vocab.IsItemCollection(it) {
+		_ = vocab.OnItemCollection(it, func(col *vocab.ItemCollection) error {
+			items = append(items, col.Collection()...)
+			return nil
+		})
+		return items
+	}
+	return append(items, it)
+}
+
+// registrationAttachmentNames are the names of the root actor's attachments that we consider
+// to be describing the registration status of the instance.
+var registrationAttachmentNames = []string{"registration", "registrations", "register", "signup", "sign up"}
+
+func isRegistrationAttachment(it vocab.Item) bool {
+	name := strings.ToLower(strings.TrimSpace(vocab.NameOf(it)))
+	for _, n := range registrationAttachmentNames {
+		if name == n {
+			return true
+		}
+	}
+	return false
 }
 
-func (n NodeInfoResolver) Usage() (nodeinfo.Usage, error) {
-	u := nodeinfo.Usage{
-		Users: nodeinfo.UsageUsers{
+// registrationStatusFromValue parses the value of a registration attachment. The second
+// return value is false when the value can not be interpreted.
+func registrationStatusFromValue(v string) (bool, bool) {
+	switch strings.ToLower(stripTags(v)) {
+	case "true", "yes", "open", "enabled":
+		return true, true
+	case "false", "no", "closed", "disabled", "invite", "invite only", "approval", "approval required":
+		return false, true
+	}
+	return false, false
+}
+
+// OpenRegistrations returns if the instance represented by the app actor accepts new registrations.
+//
+// The configured value takes precedence, and when it's missing we try to infer it from the actor's
+// attachments: a link with a registration name, or an object with a registration name
+// and a value describing the status. Without such an attachment, an OAuth2 authorization end-point
+// in the actor's endpoints means that people can sign up. When nothing can be inferred we consider
+// the registrations closed.
+func OpenRegistrations(app vocab.Actor, o Options) bool {
+	if o.OpenRegistrations != nil {
+		return *o.OpenRegistrations
+	}
+	for _, att := range itemsOf(app.Attachment) {
+		if vocab.IsNil(att) || !isRegistrationAttachment(att) {
+			continue
+		}
+		if open, ok := registrationStatusFromValue(vocab.ContentOf(att)); ok {
+			return open
+		}
+		if open, ok := registrationStatusFromValue(vocab.SummaryOf(att)); ok {
+			return open
+		}
+		if att.IsLink() {
+			// NOTE(marius): a link to a registration page means that people can sign up
+			return true
+		}
+		open := false
+		_ = vocab.OnObject(att, func(ob *vocab.Object) error {
+			open = !vocab.IsNil(ob.URL)
+			return nil
+		})
+		return open
+	}
+	if app.Endpoints != nil && !vocab.IsNil(app.Endpoints.OauthAuthorizationEndpoint) {
+		// NOTE(marius): the git.sr.ht/~mariusor/authorize service which handles the OAuth2 authorization
+		// end-point is also the one where new accounts get created.
+		return true
+	}
+	return false
+}
+
+func (n NodeInfoResolver) Usage() (NodeInfoUsage, error) {
+	u := NodeInfoUsage{
+		Users: NodeInfoUsers{
 			Total: n.users,
 		},
 		LocalComments: n.comments,
@@ -106,7 +377,183 @@ const (
 
 var Version = "HEAD"
 
-func NodeInfoConfig(app vocab.Actor, ni WebInfo) nodeinfo.Config {
+// NodeInfoOptions are the per storage values used for generating the NodeInfo document.
+//
+// The software values that are empty are derived from the root actor's generator, and when that is missing
+// they fall back to the FedBOX defaults.
+type NodeInfoOptions struct {
+	SoftwareName       string
+	SoftwareVersion    string
+	SoftwareRepository string
+	SoftwareHomepage   string
+	// Private marks the node as not being open to the public.
+	Private bool
+	// StaffAccounts contains the IRIs of the actors that administrate the node.
+	StaffAccounts []string
+	// Metadata contains extra values to be added to the NodeInfo metadata.
+	Metadata map[string]any
+	// Protocols overrides the default list of supported protocols, which contains only ActivityPub.
+	Protocols []string
+	// Feeds marks that the node publishes Atom and RSS feeds.
+	Feeds            bool
+	InboundServices  []string
+	OutboundServices []string
+}
+
+// ProtocolsOf returns the configured protocols that are valid for NodeInfo, defaulting to ActivityPub.
+func ProtocolsOf(o NodeInfoOptions) []NodeProtocol {
+	protocols := make([]NodeProtocol, 0, len(o.Protocols))
+	for _, p := range o.Protocols {
+		p := NodeProtocol(strings.ToLower(strings.TrimSpace(p)))
+		for _, v := range ValidProtocols {
+			if v == p {
+				protocols = append(protocols, p)
+				break
+			}
+		}
+	}
+	if len(protocols) == 0 {
+		protocols = append(protocols, ProtocolActivityPub)
+	}
+	return protocols
+}
+
+// feedMediaTypes maps the media types of the feed links to their NodeInfo services.
+var feedMediaTypes = map[string]NodeService{
+	"application/atom+xml": ServiceAtom,
+	"application/rss+xml":  ServiceRSS,
+}
+
+// feedServiceOf returns the NodeInfo service for a link to an Atom or RSS feed.
+func feedServiceOf(it vocab.Item) (NodeService, bool) {
+	mediaType := ""
+	_ = vocab.OnLink(it, func(l *vocab.Link) error {
+		mediaType = string(l.MediaType)
+		return nil
+	})
+	s, ok := feedMediaTypes[strings.ToLower(mediaType)]
+	return s, ok
+}
+
+// ServicesOf returns the inbound and outbound services of the node.
+//
+// They are loaded from the configuration, and from the bridges that are recorded as Service or Application
+// attachments of the app actor, having the name of the third party service they connect to.
+// The feeds are also detected from the links with Atom or RSS media types in the actor's url and attachments.
+// Services that are not valid for NodeInfo are ignored.
+func ServicesOf(app vocab.Actor, o NodeInfoOptions) NodeInfoServices {
+	services := NodeInfoServices{
+		Inbound:  []NodeService{},
+		Outbound: []NodeService{},
+	}
+	if o.Feeds {
+		services.Outbound = appendService(services.Outbound, ServiceAtom)
+		services.Outbound = appendService(services.Outbound, ServiceRSS)
+	}
+	for _, s := range o.InboundServices {
+		if s := NodeService(strings.ToLower(strings.TrimSpace(s))); validService(ValidInboundServices, s) {
+			services.Inbound = appendService(services.Inbound, s)
+		}
+	}
+	for _, s := range o.OutboundServices {
+		if s := NodeService(strings.ToLower(strings.TrimSpace(s))); validService(ValidOutboundServices, s) {
+			services.Outbound = appendService(services.Outbound, s)
+		}
+	}
+
+	for _, it := range append(itemsOf(app.URL), itemsOf(app.Attachment)...) {
+		if s, ok := feedServiceOf(it); ok {
+			services.Outbound = appendService(services.Outbound, s)
+		}
+	}
+
+	isBridge := filters.HasType(vocab.ServiceType, vocab.ApplicationType)
+	for _, att := range itemsOf(app.Attachment) {
+		if vocab.IsNil(att) || !isBridge.Match(att) {
+			continue
+		}
+		s := NodeService(strings.ToLower(strings.TrimSpace(vocab.NameOf(att))))
+		if validService(ValidInboundServices, s) {
+			services.Inbound = appendService(services.Inbound, s)
+		}
+		if validService(ValidOutboundServices, s) {
+			services.Outbound = appendService(services.Outbound, s)
+		}
+	}
+	return services
+}
+
+// SoftwareOf returns the NodeInfo software information for the app actor.
+//
+// The configured values take precedence over the ones from the app's generator object.
+func SoftwareOf(app vocab.Actor, o NodeInfoOptions) NodeInfoSoftware {
+	sw := NodeInfoSoftware{
+		Name:       o.SoftwareName,
+		Version:    o.SoftwareVersion,
+		Repository: o.SoftwareRepository,
+		Homepage:   o.SoftwareHomepage,
+	}
+	if vocab.IsObject(app.Generator) {
+		_ = vocab.OnObject(app.Generator, func(gen *vocab.Object) error {
+			name, version := nameAndVersion(vocab.NameOf(gen))
+			if sw.Name == "" {
+				sw.Name = name
+			}
+			if sw.Version == "" && strings.EqualFold(sw.Name, name) {
+				sw.Version = version
+			}
+			if sw.Homepage == "" && !vocab.IsNil(gen.URL) {
+				sw.Homepage = string(gen.URL.GetLink())
+			}
+			return nil
+		})
+	}
+	if sw.Name == "" {
+		sw.Name = path.Base(softwareName)
+		if sw.Repository == "" {
+			sw.Repository = sourceURL
+		}
+	}
+	if sw.Version == "" {
+		sw.Version = Version
+	}
+	return sw
+}
+
+// nameAndVersion splits a generator name like "FedBOX v1.2.3" into the software name and its version.
+//
+// When the last word of the name doesn't look like a version, the version is empty.
+func nameAndVersion(s string) (string, string) {
+	words := strings.Fields(s)
+	if len(words) < 2 {
+		return strings.TrimSpace(s), ""
+	}
+	last := words[len(words)-1]
+	ver := strings.TrimPrefix(strings.TrimPrefix(last, "v"), "V")
+	if ver == "" || ver[0] < '0' || ver[0] > '9' {
+		return strings.Join(words, " "), ""
+	}
+	return strings.Join(words[:len(words)-1], " "), ver
+}
+
+// stripTags removes the HTML markup from s, and un-escapes the remaining entities.
+func stripTags(s string) string {
+	b := strings.Builder{}
+	inTag := false
+	for _, c := range s {
+		switch {
+		case c == '<':
+			inTag = true
+		case c == '>' && inTag:
+			inTag = false
+		case !inTag:
+			b.WriteRune(c)
+		}
+	}
+	return strings.TrimSpace(html.UnescapeString(b.String()))
+}
+
+func NodeInfoConfig(app vocab.Actor, ni WebInfo, o NodeInfoOptions) NodeInfo {
 	var baseURL string
 	if !vocab.IsNil(app.URL) {
 		baseURL = string(app.URL.GetLink())
@@ -115,31 +562,43 @@ func NodeInfoConfig(app vocab.Actor, ni WebInfo) nodeinfo.Config {
 	if !vocab.IsNil(app.AttributedTo) {
 		attributedToURL = string(app.AttributedTo.GetLink())
 	}
-	return nodeinfo.Config{
-		BaseURL: baseURL,
-		InfoURL: "/nodeinfo",
-
-		Metadata: nodeinfo.Metadata{
-			NodeName:        string(regexp.MustCompile(`<[/\w]+>`).ReplaceAll([]byte(ni.Title), []byte{})),
-			NodeDescription: ni.Summary,
-			Private:         false,
-			Software: nodeinfo.SoftwareMeta{
-				GitHub:   sourceURL,
-				HomePage: baseURL,
-				Follow:   attributedToURL,
-			},
-		},
-		Protocols: []nodeinfo.NodeProtocol{
-			nodeinfo.ProtocolActivityPub,
-		},
-		Services: nodeinfo.Services{
-			Inbound:  []nodeinfo.NodeService{},
-			Outbound: []nodeinfo.NodeService{},
+	sw := SoftwareOf(app, o)
+	homepage := sw.Homepage
+	if homepage == "" {
+		homepage = baseURL
+	}
+
+	nodeName := stripTags(ni.Title)
+	meta := NodeInfoMetadata{
+		"nodeName":        nodeName,
+		"nodeDescription": ni.Summary,
+		"private":         o.Private,
+		"software": map[string]string{
+			"github":   sw.Repository,
+			"homepage": homepage,
+			"follow":   attributedToURL,
 		},
-		Software: nodeinfo.SoftwareInfo{
-			Name:    path.Base(softwareName),
-			Version: Version,
+	}
+	if app.ID != "" {
+		// NOTE(marius): FEP-2677 links the application actor from the NodeInfo discovery document,
+		// we also expose it in the metadata for the consumers that only read the NodeInfo document.
+		meta["applicationActor"] = string(app.ID)
+	}
+	if len(o.StaffAccounts) > 0 {
+		meta["staffAccounts"] = o.StaffAccounts
+	}
+	for k, v := range o.Metadata {
+		meta[k] = v
+	}
+	return NodeInfo{
+		Instance: &NodeInfoInstance{
+			Name:        nodeName,
+			Description: ni.Summary,
 		},
+		Metadata:  meta,
+		Protocols: ProtocolsOf(o),
+		Services:  ServicesOf(app, o),
+		Software:  sw,
 	}
 }
 
@@ -167,11 +626,63 @@ func IconOf(it vocab.Item) string {
 	}
 	return iconURL
 }
-func (h *handler) setupNodeInfo(r *http.Request) (*nodeinfo.Service, error) {
+
+type nodeInfoService struct {
+	baseURL  string
+	info     NodeInfo
+	resolver NodeInfoResolver
+
+	app vocab.Actor
+	db  Storage
+}
+
+// BuildInfo returns the NodeInfo document for the received schema version, with the usage statistics
+// and registration status filled in by the resolver.
+func (s nodeInfoService) BuildInfo(version string) (NodeInfo, error) {
+	ni := s.info
+
+	var err error
+	if ni.OpenRegistrations, err = s.resolver.IsOpenRegistration(); err != nil {
+		return ni, errors.Annotatef(err, "unable to determine registration status")
+	}
+	if ni.Usage, err = s.resolver.Usage(); err != nil {
+		return ni, errors.Annotatef(err, "unable to load usage statistics")
+	}
+	return ni.ForVersion(version), nil
+}
+
+type nodeInfoDiscovery struct {
+	Links []link `json:"links"`
+}
+
+// ApplicationActorRel is the link relation for the application actor in the NodeInfo discovery document.
+//
+// https://codeberg.org/fediverse/fep/src/branch/main/fep/2677/fep-2677.md
+const ApplicationActorRel = "https://www.w3.org/ns/activitystreams#Application"
+
+func nodeInfoDiscoveryLinks(baseURL string, app vocab.IRI) nodeInfoDiscovery {
+	baseURL = strings.TrimRight(baseURL, "/")
+	d := nodeInfoDiscovery{Links: make([]link, 0, len(NodeInfoVersions)+1)}
+	for _, v := range NodeInfoVersions {
+		d.Links = append(d.Links, link{
+			Rel:  nodeInfoProfile(v),
+			Href: baseURL + NodeInfoPath + "/" + v,
+		})
+	}
+	if app != "" {
+		d.Links = append(d.Links, link{Rel: ApplicationActorRel, Href: app.String()})
+	}
+	return d
+}
+
+func (h *handler) setupNodeInfo(r *http.Request) (*nodeInfoService, error) {
 	storage, err := h.findMatchingStorage(baseURL(r)...)
 	if err != nil {
 		return nil, err
 	}
+	if storage, err = h.authorizeRequest(r, storage); err != nil {
+		return nil, err
+	}
 	var app vocab.Actor
 
 	maybeActor := storage.Root
@@ -182,6 +693,12 @@ func (h *handler) setupNodeInfo(r *http.Request) (*nodeinfo.Service, error) {
 		return nil, errors.NotFoundf("root actor not found")
 	}
 
+	if vocab.IsIRI(app.Generator) {
+		if gen, err := storage.Load(app.Generator.GetLink()); err == nil && !vocab.IsNil(gen) {
+			app.Generator = gen
+		}
+	}
+
 	name := vocab.NameOf(app)
 	if name == "" {
 		name = vocab.PreferredNameOf(app)
@@ -195,8 +712,14 @@ func (h *handler) setupNodeInfo(r *http.Request) (*nodeinfo.Service, error) {
 		URI:         string(app.ID),
 		Urls:        nil,
 		Version:     Version,
-	})
-	return nodeinfo.NewService(cfg, NodeInfoResolverNew(aggRepo(storage), app)), nil
+	}, storage.Options.NodeInfo)
+	baseURL := string(app.ID)
+	if !vocab.IsNil(app.URL) {
+		baseURL = string(app.URL.GetLink())
+	}
+	resolver := NodeInfoResolverNew(aggRepo(storage), app)
+	resolver.openRegistrations = OpenRegistrations(app, storage.Options)
+	return &nodeInfoService{baseURL: baseURL, info: cfg, resolver: resolver, app: app, db: storage}, nil
 }
 
 const NodeInfoDiscoverPath = "/.well-known/nodeinfo"
@@ -209,20 +732,52 @@ func (h handler) NodeInfoDiscover(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ni.NodeInfoDiscover(w, r)
+	dat, err := json.Marshal(nodeInfoDiscoveryLinks(ni.baseURL, ni.app.ID))
+	if err != nil {
+		handleErr(h.l)(r, errors.Annotatef(err, "unable to marshal NodeInfo discovery")).ServeHTTP(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(dat)
 	h.l.Debugf("%s %s%s %d %s", r.Method, r.Host, r.RequestURI, http.StatusOK, http.StatusText(http.StatusOK))
 }
 
 const NodeInfoPath = "/nodeinfo"
 
-// NodeInfo handles "/nodeinfo"
+// NodeInfo handles "/nodeinfo" and the versioned "/nodeinfo/{version}" end-points.
+//
+// The un-versioned path serves the 2.0 schema, as it did before we supported multiple versions.
 func (h handler) NodeInfo(w http.ResponseWriter, r *http.Request) {
+	version := NodeInfoVersion20
+	if p := strings.TrimSuffix(r.URL.Path, ".json"); p != NodeInfoPath {
+		version = path.Base(p)
+	}
+	if !validNodeInfoVersion(version) {
+		handleErr(h.l)(r, errors.NotFoundf("NodeInfo schema version %s is not supported", version)).ServeHTTP(w, r)
+		return
+	}
+
 	ni, err := h.setupNodeInfo(r)
 	if err != nil {
 		handleErr(h.l)(r, err).ServeHTTP(w, r)
 		return
 	}
 
-	ni.NodeInfo(w, r)
+	info, err := ni.BuildInfo(version)
+	if err != nil {
+		handleErr(h.l)(r, err).ServeHTTP(w, r)
+		return
+	}
+	dat, err := json.Marshal(info)
+	if err != nil {
+		handleErr(h.l)(r, errors.Annotatef(err, "unable to marshal NodeInfo")).ServeHTTP(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", `application/json; profile="`+nodeInfoProfile(version)+`#"`)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(dat)
 	h.l.Debugf("%s %s%s %d %s", r.Method, r.Host, r.RequestURI, http.StatusOK, http.StatusText(http.StatusOK))
 }
@@ -0,0 +1,122 @@
+package webfinger
+
+import (
+	"encoding/json"
+	"net/http"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/filters"
+)
+
+const nodeInfo2Version = "1.0"
+
+type (
+	// NodeInfo2 is the document served at the x-nodeinfo2 end-point.
+	//
+	// https://github.com/jaywink/nodeinfo2
+	NodeInfo2 struct {
+		Version           string                `json:"version"`
+		Server            NodeInfo2Server       `json:"server"`
+		Organization      NodeInfo2Organization `json:"organization"`
+		Protocols         []NodeProtocol        `json:"protocols"`
+		Services          NodeInfoServices      `json:"services"`
+		OpenRegistrations bool                  `json:"openRegistrations"`
+		Usage             NodeInfoUsage         `json:"usage"`
+	}
+
+	NodeInfo2Server struct {
+		BaseURL  string `json:"baseUrl"`
+		Name     string `json:"name"`
+		Software string `json:"software"`
+		Version  string `json:"version"`
+	}
+
+	NodeInfo2Organization struct {
+		Name    string `json:"name,omitempty"`
+		Contact string `json:"contact,omitempty"`
+		Account string `json:"account,omitempty"`
+	}
+)
+
+// OrganizationOf returns the NodeInfo2 organization information from the actor that owns the instance.
+func OrganizationOf(owner vocab.Actor, contact string) NodeInfo2Organization {
+	org := NodeInfo2Organization{
+		Name:    vocab.NameOf(owner),
+		Contact: contact,
+		Account: string(owner.ID),
+	}
+	if org.Name == "" {
+		org.Name = vocab.PreferredNameOf(owner)
+	}
+	if !vocab.IsNil(owner.URL) {
+		org.Account = string(owner.URL.GetLink())
+	}
+	return org
+}
+
+// BuildInfo2 returns the NodeInfo2 document, using the same values as the NodeInfo ones.
+func (s nodeInfoService) BuildInfo2() (NodeInfo2, error) {
+	// NOTE(marius): the 2.2 version contains all the values we're interested in
+	ni, err := s.BuildInfo(NodeInfoVersion22)
+	if err != nil {
+		return NodeInfo2{}, err
+	}
+
+	ni2 := NodeInfo2{
+		Version: nodeInfo2Version,
+		Server: NodeInfo2Server{
+			BaseURL:  s.baseURL,
+			Software: ni.Software.Name,
+			Version:  ni.Software.Version,
+		},
+		Protocols:         ni.Protocols,
+		Services:          ni.Services,
+		OpenRegistrations: ni.OpenRegistrations,
+		Usage:             ni.Usage,
+	}
+	if ni.Instance != nil {
+		ni2.Server.Name = ni.Instance.Name
+	}
+
+	if vocab.IsNil(s.app.AttributedTo) {
+		return ni2, nil
+	}
+	ni2.Organization.Account = string(s.app.AttributedTo.GetLink())
+	owner, err := LoadActor(s.db, filters.SameID(s.app.AttributedTo.GetLink()))
+	if err != nil || vocab.IsNil(owner) {
+		return ni2, nil
+	}
+	_ = vocab.OnActor(owner, func(owner *vocab.Actor) error {
+		ni2.Organization = OrganizationOf(*owner, s.db.Options.Instance.Email)
+		return nil
+	})
+	return ni2, nil
+}
+
+const NodeInfo2Path = "/.well-known/x-nodeinfo2"
+
+// NodeInfo2 handles "/.well-known/x-nodeinfo2"
+func (h handler) NodeInfo2(w http.ResponseWriter, r *http.Request) {
+	ni, err := h.setupNodeInfo(r)
+	if err != nil {
+		handleErr(h.l)(r, err).ServeHTTP(w, r)
+		return
+	}
+
+	info, err := ni.BuildInfo2()
+	if err != nil {
+		handleErr(h.l)(r, err).ServeHTTP(w, r)
+		return
+	}
+	dat, err := json.Marshal(info)
+	if err != nil {
+		handleErr(h.l)(r, errors.Annotatef(err, "unable to marshal NodeInfo2")).ServeHTTP(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(dat)
+	h.l.Debugf("%s %s%s %d %s", r.Method, r.Host, r.RequestURI, http.StatusOK, http.StatusText(http.StatusOK))
+}
@@ -0,0 +1,521 @@
+package webfinger
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+// validateSchema checks val against the subset of JSON schema draft-04 keywords used by the NodeInfo schemas.
+func validateSchema(schema map[string]any, val any, at string) []error {
+	errs := make([]error, 0)
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, e := range enum {
+			if e == val {
+				found = true
+			}
+		}
+		if !found {
+			errs = append(errs, fmt.Errorf("%s: value %v is not one of %v", at, val, enum))
+		}
+	}
+	switch schema["type"] {
+	case "object":
+		ob, ok := val.(map[string]any)
+		if !ok {
+			return append(errs, fmt.Errorf("%s: expected object, got %T", at, val))
+		}
+		props, _ := schema["properties"].(map[string]any)
+		if req, ok := schema["required"].([]any); ok {
+			for _, r := range req {
+				if _, ok := ob[r.(string)]; !ok {
+					errs = append(errs, fmt.Errorf("%s: missing required property %q", at, r))
+				}
+			}
+		}
+		keys := make([]string, 0, len(ob))
+		for k := range ob {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			sub, ok := props[k].(map[string]any)
+			if !ok {
+				if additional, ok := schema["additionalProperties"].(bool); ok && !additional {
+					errs = append(errs, fmt.Errorf("%s: additional property %q is not allowed", at, k))
+				}
+				continue
+			}
+			errs = append(errs, validateSchema(sub, ob[k], at+"."+k)...)
+		}
+	case "array":
+		ar, ok := val.([]any)
+		if !ok {
+			return append(errs, fmt.Errorf("%s: expected array, got %T", at, val))
+		}
+		if min, ok := schema["minItems"].(float64); ok && float64(len(ar)) < min {
+			errs = append(errs, fmt.Errorf("%s: expected at least %v items, got %d", at, min, len(ar)))
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, it := range ar {
+				errs = append(errs, validateSchema(items, it, fmt.Sprintf("%s[%d]", at, i))...)
+			}
+		}
+	case "string":
+		s, ok := val.(string)
+		if !ok {
+			return append(errs, fmt.Errorf("%s: expected string, got %T", at, val))
+		}
+		if pat, ok := schema["pattern"].(string); ok && !regexp.MustCompile(pat).MatchString(s) {
+			errs = append(errs, fmt.Errorf("%s: %q does not match %s", at, s, pat))
+		}
+	case "integer":
+		n, ok := val.(float64)
+		if !ok || n != float64(int64(n)) {
+			return append(errs, fmt.Errorf("%s: expected integer, got %v", at, val))
+		}
+		if min, ok := schema["minimum"].(float64); ok && n < min {
+			errs = append(errs, fmt.Errorf("%s: %v is lower than %v", at, n, min))
+		}
+	case "boolean":
+		if _, ok := val.(bool); !ok {
+			errs = append(errs, fmt.Errorf("%s: expected boolean, got %T", at, val))
+		}
+	}
+	return errs
+}
+
+func loadNodeInfoSchema(t *testing.T, version string) map[string]any {
+	t.Helper()
+	raw, err := os.ReadFile(filepath.Join("testdata", "nodeinfo", "schema-"+version+".json"))
+	if err != nil {
+		t.Fatalf("unable to load schema for version %s: %s", version, err)
+	}
+	schema := make(map[string]any)
+	if err = json.Unmarshal(raw, &schema); err != nil {
+		t.Fatalf("unable to parse schema for version %s: %s", version, err)
+	}
+	return schema
+}
+
+func TestNodeInfo_ForVersion(t *testing.T) {
+	app := vocab.Actor{
+		ID:           "https://example.com",
+		URL:          vocab.IRI("https://example.com/"),
+		AttributedTo: vocab.IRI("https://example.com/actors/admin"),
+	}
+	info := NodeInfoConfig(app, WebInfo{Title: "<b>Example</b> node", Summary: "Example summary"}, NodeInfoOptions{})
+	if info.Metadata["applicationActor"] != "https://example.com" {
+		t.Errorf("Invalid applicationActor metadata %v, expected %s", info.Metadata["applicationActor"], app.ID)
+	}
+	info.Usage = NodeInfoUsage{
+		Users:         NodeInfoUsers{Total: 3, ActiveWeek: 1},
+		LocalPosts:    10,
+		LocalComments: 22,
+	}
+
+	for _, version := range NodeInfoVersions {
+		t.Run(version, func(t *testing.T) {
+			dat, err := json.Marshal(info.ForVersion(version))
+			if err != nil {
+				t.Fatalf("unable to marshal NodeInfo %s: %s", version, err)
+			}
+			doc := make(map[string]any)
+			if err = json.Unmarshal(dat, &doc); err != nil {
+				t.Fatalf("unable to unmarshal NodeInfo %s: %s", version, err)
+			}
+			for _, err := range validateSchema(loadNodeInfoSchema(t, version), doc, "$") {
+				t.Errorf("NodeInfo %s is not valid: %s", version, err)
+			}
+		})
+	}
+}
+
+func TestNodeInfo_ForVersionDefaultProtocols(t *testing.T) {
+	info := NodeInfo{Software: NodeInfoSoftware{Name: softwareName, Version: Version}}
+	for _, version := range NodeInfoVersions {
+		ni := info.ForVersion(version)
+		if len(ni.Protocols) != 1 || ni.Protocols[0] != ProtocolActivityPub {
+			t.Errorf("Invalid protocols %v for %s, expected [%s]", ni.Protocols, version, ProtocolActivityPub)
+		}
+		dat, _ := json.Marshal(ni)
+		doc := make(map[string]any)
+		_ = json.Unmarshal(dat, &doc)
+		for _, err := range validateSchema(loadNodeInfoSchema(t, version), doc, "$") {
+			t.Errorf("NodeInfo %s is not valid: %s", version, err)
+		}
+	}
+}
+
+func TestSoftwareOf(t *testing.T) {
+	generator := itemFromJSON(t, `{"type":"Application","name":"brutalinks v1.2.3","url":"https://brutalinks.tech"}`)
+	tests := []struct {
+		name string
+		gen  vocab.Item
+		opts NodeInfoOptions
+		want NodeInfoSoftware
+	}{
+		{
+			name: "defaults",
+			want: NodeInfoSoftware{Name: softwareName, Version: Version, Repository: sourceURL},
+		},
+		{
+			name: "generator",
+			gen:  generator,
+			want: NodeInfoSoftware{Name: "brutalinks", Version: "1.2.3", Homepage: "https://brutalinks.tech"},
+		},
+		{
+			name: "generator without version",
+			gen:  itemFromJSON(t, `{"type":"Application","name":"oni"}`),
+			want: NodeInfoSoftware{Name: "oni", Version: Version},
+		},
+		{
+			name: "configured name ignores the generator version",
+			gen:  generator,
+			opts: NodeInfoOptions{SoftwareName: "oni"},
+			want: NodeInfoSoftware{Name: "oni", Version: Version, Homepage: "https://brutalinks.tech"},
+		},
+		{
+			name: "configured version takes precedence",
+			gen:  generator,
+			opts: NodeInfoOptions{SoftwareVersion: "2.0.0"},
+			want: NodeInfoSoftware{Name: "brutalinks", Version: "2.0.0", Homepage: "https://brutalinks.tech"},
+		},
+		{
+			name: "configured",
+			opts: NodeInfoOptions{
+				SoftwareName:       "brutalinks",
+				SoftwareVersion:    "v1.0.0",
+				SoftwareRepository: "https://git.sr.ht/~mariusor/brutalinks",
+				SoftwareHomepage:   "https://brutalinks.tech",
+			},
+			want: NodeInfoSoftware{
+				Name:       "brutalinks",
+				Version:    "v1.0.0",
+				Repository: "https://git.sr.ht/~mariusor/brutalinks",
+				Homepage:   "https://brutalinks.tech",
+			},
+		},
+		{
+			name: "only name",
+			opts: NodeInfoOptions{SoftwareName: "oni"},
+			want: NodeInfoSoftware{Name: "oni", Version: Version},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SoftwareOf(vocab.Actor{ID: "https://example.com", Generator: tt.gen}, tt.opts); got != tt.want {
+				t.Errorf("SoftwareOf() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNodeInfoConfig_homepage(t *testing.T) {
+	app := vocab.Actor{ID: "https://example.com", URL: vocab.IRI("https://example.com/")}
+	info := NodeInfoConfig(app, WebInfo{}, NodeInfoOptions{SoftwareHomepage: "https://brutalinks.tech"})
+	sw, _ := info.Metadata["software"].(map[string]string)
+	if sw["homepage"] != info.Software.Homepage {
+		t.Errorf("Invalid metadata homepage %q, expected the software homepage %q", sw["homepage"], info.Software.Homepage)
+	}
+
+	info = NodeInfoConfig(app, WebInfo{}, NodeInfoOptions{})
+	if sw, _ = info.Metadata["software"].(map[string]string); sw["homepage"] != "https://example.com/" {
+		t.Errorf("Invalid metadata homepage %q, expected the instance URL when no homepage is known", sw["homepage"])
+	}
+}
+
+func TestStripTags(t *testing.T) {
+	tests := map[string]string{
+		"plain":                               "plain",
+		"<b>bold</b> text":                    "bold text",
+		`<a href="https://example.com">a</a>`: "a",
+		"Tom &amp; Jerry<br/>":                "Tom & Jerry",
+	}
+	for in, want := range tests {
+		if got := stripTags(in); got != want {
+			t.Errorf("stripTags(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestProtocolsOf(t *testing.T) {
+	tests := []struct {
+		name string
+		opts NodeInfoOptions
+		want []NodeProtocol
+	}{
+		{name: "default", want: []NodeProtocol{ProtocolActivityPub}},
+		{
+			name: "configured",
+			opts: NodeInfoOptions{Protocols: []string{"activitypub", "Diaspora"}},
+			want: []NodeProtocol{ProtocolActivityPub, ProtocolDiaspora},
+		},
+		{
+			name: "invalid values are skipped",
+			opts: NodeInfoOptions{Protocols: []string{"gemini"}},
+			want: []NodeProtocol{ProtocolActivityPub},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ProtocolsOf(tt.opts)
+			if fmt.Sprintf("%v", got) != fmt.Sprintf("%v", tt.want) {
+				t.Errorf("ProtocolsOf() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServicesOf(t *testing.T) {
+	tests := []struct {
+		name string
+		opts NodeInfoOptions
+		want NodeInfoServices
+	}{
+		{
+			name: "empty",
+			want: NodeInfoServices{Inbound: []NodeService{}, Outbound: []NodeService{}},
+		},
+		{
+			name: "feeds",
+			opts: NodeInfoOptions{Feeds: true, OutboundServices: []string{"rss2.0"}},
+			want: NodeInfoServices{Inbound: []NodeService{}, Outbound: []NodeService{ServiceAtom, ServiceRSS}},
+		},
+		{
+			name: "configured",
+			opts: NodeInfoOptions{InboundServices: []string{"twitter", "smtp"}, OutboundServices: []string{"smtp", "bogus"}},
+			want: NodeInfoServices{Inbound: []NodeService{ServiceTwitter}, Outbound: []NodeService{ServiceSMTP}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ServicesOf(vocab.Actor{ID: "https://example.com"}, tt.opts)
+			if fmt.Sprintf("%v", got) != fmt.Sprintf("%v", tt.want) {
+				t.Errorf("ServicesOf() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServicesOf_detected(t *testing.T) {
+	tests := []struct {
+		name  string
+		actor string
+		opts  NodeInfoOptions
+		want  NodeInfoServices
+	}{
+		{
+			name:  "bridges",
+			actor: `{"attachment":[{"type":"Service","name":"Twitter"},{"type":"Application","name":"IMAP"},{"type":"Note","name":"smtp"}]}`,
+			want:  NodeInfoServices{Inbound: []NodeService{ServiceTwitter, ServiceIMAP}, Outbound: []NodeService{ServiceTwitter}},
+		},
+		{
+			name:  "unknown bridge",
+			actor: `{"attachment":{"type":"Service","name":"Friendster"}}`,
+			want:  NodeInfoServices{Inbound: []NodeService{}, Outbound: []NodeService{}},
+		},
+		{
+			name:  "feed links",
+			actor: `{"url":[{"type":"Link","href":"https://example.com/feed.atom","mediaType":"application/atom+xml"},"https://example.com/"],"attachment":[{"type":"Link","href":"https://example.com/feed.rss","mediaType":"application/rss+xml"}]}`,
+			want:  NodeInfoServices{Inbound: []NodeService{}, Outbound: []NodeService{ServiceAtom, ServiceRSS}},
+		},
+		{
+			name:  "configured and detected",
+			actor: `{"attachment":[{"type":"Service","name":"twitter"},{"type":"Link","href":"https://example.com/feed.atom","mediaType":"application/atom+xml"}]}`,
+			opts:  NodeInfoOptions{Feeds: true, InboundServices: []string{"twitter"}},
+			want:  NodeInfoServices{Inbound: []NodeService{ServiceTwitter}, Outbound: []NodeService{ServiceAtom, ServiceRSS, ServiceTwitter}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := `{"id":"https://example.com","type":"Application",` + tt.actor[1:]
+			it, err := vocab.UnmarshalJSON([]byte(raw))
+			if err != nil {
+				t.Fatalf("unable to unmarshal actor: %s", err)
+			}
+			app, err := vocab.ToActor(it)
+			if err != nil {
+				t.Fatalf("invalid actor: %s", err)
+			}
+			got := ServicesOf(*app, tt.opts)
+			if fmt.Sprintf("%v", got) != fmt.Sprintf("%v", tt.want) {
+				t.Errorf("ServicesOf() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenRegistrations(t *testing.T) {
+	open, closed := true, false
+	app := vocab.Actor{ID: "https://example.com"}
+	tests := []struct {
+		name string
+		opts Options
+		want bool
+	}{
+		{name: "not configured", opts: Options{}, want: false},
+		{name: "configured open", opts: Options{OpenRegistrations: &open}, want: true},
+		{name: "configured closed", opts: Options{OpenRegistrations: &closed}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := OpenRegistrations(app, tt.opts); got != tt.want {
+				t.Errorf("OpenRegistrations() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenRegistrations_inferred(t *testing.T) {
+	open := true
+	tests := []struct {
+		name        string
+		attachments string
+		endpoints   string
+		opts        Options
+		want        bool
+	}{
+		{name: "no attachments", attachments: `[]`, want: false},
+		{
+			name:        "unrelated attachment",
+			attachments: `[{"type":"Note","name":"Website","content":"open"}]`,
+			want:        false,
+		},
+		{
+			name:        "open status",
+			attachments: `[{"type":"Note","name":"Registrations","content":"<p>Open</p>"}]`,
+			want:        true,
+		},
+		{
+			name:        "closed status in summary",
+			attachments: `[{"type":"Note","name":"registration","summary":"invite only"}]`,
+			want:        false,
+		},
+		{
+			name:        "sign up page",
+			attachments: `[{"type":"Link","name":"Sign up","href":"https://example.com/register"}]`,
+			want:        true,
+		},
+		{
+			name:        "sign up page in the object url",
+			attachments: `[{"type":"Note","name":"Register","url":"https://example.com/register"}]`,
+			want:        true,
+		},
+		{
+			name:        "authorization end-point",
+			attachments: `[]`,
+			endpoints:   `{"oauthAuthorizationEndpoint":"https://example.com/oauth/authorize"}`,
+			want:        true,
+		},
+		{
+			name:        "shared inbox only",
+			attachments: `[]`,
+			endpoints:   `{"sharedInbox":"https://example.com/inbox"}`,
+			want:        false,
+		},
+		{
+			name:        "attachment takes precedence over the end-points",
+			attachments: `[{"type":"Note","name":"Registrations","content":"closed"}]`,
+			endpoints:   `{"oauthAuthorizationEndpoint":"https://example.com/oauth/authorize"}`,
+			want:        false,
+		},
+		{
+			name:        "configuration takes precedence",
+			attachments: `[{"type":"Note","name":"Registrations","content":"closed"}]`,
+			opts:        Options{OpenRegistrations: &open},
+			want:        true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := `{"id":"https://example.com","type":"Application","attachment":` + tt.attachments
+			if tt.endpoints != "" {
+				raw += `,"endpoints":` + tt.endpoints
+			}
+			it, err := vocab.UnmarshalJSON([]byte(raw + `}`))
+			if err != nil {
+				t.Fatalf("unable to unmarshal actor: %s", err)
+			}
+			app, err := vocab.ToActor(it)
+			if err != nil {
+				t.Fatalf("invalid actor: %s", err)
+			}
+			if got := OpenRegistrations(*app, tt.opts); got != tt.want {
+				t.Errorf("OpenRegistrations() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistrationStatusFromValue(t *testing.T) {
+	tests := []struct {
+		value    string
+		wantOpen bool
+		wantOk   bool
+	}{
+		{value: "open", wantOpen: true, wantOk: true},
+		{value: "<p>Closed</p>", wantOpen: false, wantOk: true},
+		{value: "true", wantOpen: true, wantOk: true},
+		{value: "https://example.com/register", wantOpen: false, wantOk: false},
+	}
+	for _, tt := range tests {
+		open, ok := registrationStatusFromValue(tt.value)
+		if open != tt.wantOpen || ok != tt.wantOk {
+			t.Errorf("registrationStatusFromValue(%q) = %t, %t, want %t, %t", tt.value, open, ok, tt.wantOpen, tt.wantOk)
+		}
+	}
+}
+
+func TestNodeInfoDiscoveryLinks(t *testing.T) {
+	d := nodeInfoDiscoveryLinks("https://example.com/", "https://example.com/")
+	if len(d.Links) != len(NodeInfoVersions)+1 {
+		t.Fatalf("Invalid number of discovery links %d, expected %d", len(d.Links), len(NodeInfoVersions)+1)
+	}
+	for i, version := range NodeInfoVersions {
+		l := d.Links[i]
+		if wantRel := "http://nodeinfo.diaspora.software/ns/schema/" + version; l.Rel != wantRel {
+			t.Errorf("Invalid rel %s, expected %s", l.Rel, wantRel)
+		}
+		if wantHref := "https://example.com/nodeinfo/" + version; l.Href != wantHref {
+			t.Errorf("Invalid href %s, expected %s", l.Href, wantHref)
+		}
+	}
+	if l := d.Links[len(NodeInfoVersions)]; l.Rel != ApplicationActorRel || l.Href != "https://example.com/" {
+		t.Errorf("Invalid application actor link %+v", l)
+	}
+}
+
+func TestNodeInfoService_BuildInfo2(t *testing.T) {
+	app := vocab.Actor{ID: "https://example.com", URL: vocab.IRI("https://example.com")}
+	s := nodeInfoService{
+		baseURL:  "https://example.com",
+		info:     NodeInfoConfig(app, WebInfo{Title: "Example"}, NodeInfoOptions{}),
+		resolver: NodeInfoResolver{users: 2, posts: 4, openRegistrations: true},
+		app:      app,
+	}
+	ni2, err := s.BuildInfo2()
+	if err != nil {
+		t.Fatalf("BuildInfo2() returned error %s", err)
+	}
+	if ni2.Version != "1.0" {
+		t.Errorf("Invalid version %s, expected %s", ni2.Version, "1.0")
+	}
+	want := NodeInfo2Server{BaseURL: "https://example.com", Name: "Example", Software: "fedbox", Version: Version}
+	if ni2.Server != want {
+		t.Errorf("Invalid server %#v, expected %#v", ni2.Server, want)
+	}
+	if !ni2.OpenRegistrations {
+		t.Errorf("Invalid openRegistrations %t, expected %t", ni2.OpenRegistrations, true)
+	}
+	if ni2.Usage.Users.Total != 2 || ni2.Usage.LocalPosts != 4 {
+		t.Errorf("Invalid usage %+v", ni2.Usage)
+	}
+}
@@ -1,9 +1,11 @@
 package webfinger
 
 import (
+	"crypto"
 	"encoding/json"
 	"net/http"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	vocab "github.com/go-ap/activitypub"
@@ -25,6 +27,7 @@ type OAuthAuthorizationMetadata struct {
 	Issuer                                     string                   `json:"issuer"`
 	AuthorizationEndpoint                      string                   `json:"authorization_endpoint"`
 	TokenEndpoint                              string                   `json:"token_endpoint"`
+	JWKsURI                                    string                   `json:"jwks_uri,omitempty"`
 	TokenEndpointAuthMethodsSupported          []string                 `json:"token_endpoint_auth_methods_supported,omitempty"`
 	TokenEndpointAuthSigningAlgValuesSupported []string                 `json:"token_endpoint_auth_signing_alg_values_supported,omitempty"`
 	RegistrationEndpoint                       string                   `json:"registration_endpoint"`
@@ -34,6 +37,85 @@ type OAuthAuthorizationMetadata struct {
 	ClientIDMetadataDocumentSupported          bool                     `json:"client_id_metadata_document_supported"`
 	ServiceDocumentation                       string                   `json:"service_documentation,omitempty"`
 	CodeChallengeMethodsSupported              []string                 `json:"code_challenge_methods_supported,omitempty"`
+	RevocationEndpoint                         string                   `json:"revocation_endpoint,omitempty"`
+	IntrospectionEndpoint                      string                   `json:"introspection_endpoint,omitempty"`
+	DPoPSigningAlgValuesSupported              []string                 `json:"dpop_signing_alg_values_supported,omitempty"`
+	SignedMetadata                             string                   `json:"signed_metadata,omitempty"`
+}
+
+// OAuthOptions are the per storage values used for generating the OAuth2 metadata documents.
+//
+// The empty values are replaced with the defaults that correspond to the go-ap/authorize service.
+type OAuthOptions struct {
+	// Scopes are the OAuth2 scopes supported by the authorization server and the protected resources.
+	Scopes []string
+	// GrantTypes are the enabled OAuth2 grants, the response types supported are derived from them.
+	GrantTypes                   []string
+	TokenEndpointAuthMethods     []string
+	TokenEndpointAuthSigningAlgs []string
+	CodeChallengeMethods         []string
+	DPoPSigningAlgs              []string
+	ClientIDMetadataDocument     *bool
+	RevocationEndpoint           string
+	IntrospectionEndpoint        string
+	ServiceDocumentation         string
+
+	// SignMetadata enables the RFC8414 signed_metadata value, which is signed with the SigningKey,
+	// or when that's missing with the private key of the service actor.
+	SignMetadata bool
+	SigningKey   crypto.Signer
+
+	// Issuers contains the values that override the storage ones for specific issuer actors.
+	Issuers map[vocab.IRI]OAuthOptions
+
+	// Prober, when set, is used to load the capabilities of the authorize service,
+	// which replace the configured ones.
+	Prober *AuthorizeProber
+}
+
+// ForIssuer returns the options for the issuer, with its specific values overriding the storage ones.
+func (o OAuthOptions) ForIssuer(iri vocab.IRI) OAuthOptions {
+	io, ok := o.Issuers[iri]
+	if !ok {
+		return o
+	}
+	res := o
+	res.Issuers = nil
+	if io.Scopes != nil {
+		res.Scopes = io.Scopes
+	}
+	if io.GrantTypes != nil {
+		res.GrantTypes = io.GrantTypes
+	}
+	if io.TokenEndpointAuthMethods != nil {
+		res.TokenEndpointAuthMethods = io.TokenEndpointAuthMethods
+	}
+	if io.TokenEndpointAuthSigningAlgs != nil {
+		res.TokenEndpointAuthSigningAlgs = io.TokenEndpointAuthSigningAlgs
+	}
+	if io.CodeChallengeMethods != nil {
+		res.CodeChallengeMethods = io.CodeChallengeMethods
+	}
+	if io.DPoPSigningAlgs != nil {
+		res.DPoPSigningAlgs = io.DPoPSigningAlgs
+	}
+	if io.ClientIDMetadataDocument != nil {
+		res.ClientIDMetadataDocument = io.ClientIDMetadataDocument
+	}
+	if io.RevocationEndpoint != "" {
+		res.RevocationEndpoint = io.RevocationEndpoint
+	}
+	if io.IntrospectionEndpoint != "" {
+		res.IntrospectionEndpoint = io.IntrospectionEndpoint
+	}
+	if io.ServiceDocumentation != "" {
+		res.ServiceDocumentation = io.ServiceDocumentation
+	}
+	if io.SigningKey != nil {
+		res.SignMetadata = true
+		res.SigningKey = io.SigningKey
+	}
+	return res
 }
 
 // DefaultAccessTypes shows which OAuth2 access types the go-ap/authorize application supports,
@@ -41,8 +123,12 @@ type OAuthAuthorizationMetadata struct {
 var DefaultAccessTypes = osin.AllowedAccessType{osin.AUTHORIZATION_CODE, osin.REFRESH_TOKEN, osin.PASSWORD, osin.CLIENT_CREDENTIALS}
 
 func defaultGrantTypes() []osin.AccessRequestType {
-	grants := make([]osin.AccessRequestType, 0, len(DefaultAccessTypes))
-	for _, typ := range DefaultAccessTypes {
+	return grantTypes(DefaultAccessTypes)
+}
+
+func grantTypes(types osin.AllowedAccessType) []osin.AccessRequestType {
+	grants := make([]osin.AccessRequestType, 0, len(types))
+	for _, typ := range types {
 		if typ == osin.IMPLICIT {
 			typ = "implicit"
 		}
@@ -51,15 +137,87 @@ func defaultGrantTypes() []osin.AccessRequestType {
 	return grants
 }
 
+// knownGrantTypes are the OAuth2 grants that can be configured, using the RFC8414 name for the implicit grant.
+var knownGrantTypes = []osin.AccessRequestType{
+	osin.AUTHORIZATION_CODE, osin.REFRESH_TOKEN, osin.PASSWORD, osin.CLIENT_CREDENTIALS, osin.ASSERTION, "implicit",
+}
+
+// ValidGrantTypes returns an error for the grant types that are not known.
+func ValidGrantTypes(grants []string) error {
+	errs := make([]error, 0)
+	for _, g := range grants {
+		if !slices.Contains(knownGrantTypes, osin.AccessRequestType(strings.TrimSpace(g))) {
+			errs = append(errs, errors.Errorf("unknown OAuth2 grant type %q", g))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (o OAuthOptions) grantTypes() []osin.AccessRequestType {
+	// NOTE(marius): an empty list, like the one resulting from restricting the grants to the probed ones, is kept
+	if o.GrantTypes == nil {
+		return defaultGrantTypes()
+	}
+	types := make(osin.AllowedAccessType, 0, len(o.GrantTypes))
+	for _, g := range o.GrantTypes {
+		typ := osin.AccessRequestType(strings.TrimSpace(g))
+		if !slices.Contains(knownGrantTypes, typ) {
+			continue
+		}
+		if typ == "implicit" {
+			typ = osin.IMPLICIT
+		}
+		types = append(types, typ)
+	}
+	return grantTypes(types)
+}
+
+// responseTypes derives the RFC8414 response_types_supported from the grant types:
+// the authorization code grant uses the "code" response type and the implicit grant uses the "token" one.
+func responseTypes(grants []osin.AccessRequestType) []string {
+	types := make([]string, 0)
+	for _, g := range grants {
+		switch g {
+		case osin.AUTHORIZATION_CODE:
+			types = append(types, string(osin.CODE))
+		case "implicit":
+			types = append(types, string(osin.TOKEN))
+		}
+	}
+	if len(types) == 0 {
+		return nil
+	}
+	return types
+}
+
+func valuesOrDefault(values []string, def ...string) []string {
+	if len(values) > 0 {
+		return values
+	}
+	return def
+}
+
 const WellKnownOAuthAuthorizationServerPath = "/.well-known/oauth-authorization-server"
 
-func issuerIRIFromOAuthAuthorizationRequest(req *http.Request) vocab.IRI {
-	maybeActorURI := "https://" + req.Host + strings.Replace(req.RequestURI, WellKnownOAuthAuthorizationServerPath, "", 1)
+// issuerIRIFromWellKnownRequest returns the IRI of the issuer from a request to a well known metadata end-point,
+// by removing the well known path from it.
+//
+// It supports both the RFC8414 style, where the well known path is inserted before the issuer's path,
+// and the OpenID Connect style, where it's appended after it.
+func issuerIRIFromWellKnownRequest(req *http.Request, wellKnownPath string) vocab.IRI {
+	maybeActorURI := "https://" + req.Host + strings.Replace(req.RequestURI, wellKnownPath, "", 1)
 	return vocab.IRI(maybeActorURI)
 }
 
-func clientRegistrationIRI(self vocab.Actor) string {
-	fallBack := self.ID.AddPath("oauth/client").String()
+func issuerIRIFromOAuthAuthorizationRequest(req *http.Request) vocab.IRI {
+	return issuerIRIFromWellKnownRequest(req, WellKnownOAuthAuthorizationServerPath)
+}
+
+// oauthEndpointIRI returns the IRI of an OAuth2 end-point of the self actor that is not part of its
+// ActivityPub endpoints. It is built as a sibling of the token end-point, and when that's missing
+// it falls back to the "oauth/..." path of the actor.
+func oauthEndpointIRI(self vocab.Actor, name string) string {
+	fallBack := self.ID.AddPath("oauth/" + name).String()
 	if self.Endpoints == nil || vocab.IsNil(self.Endpoints.OauthTokenEndpoint) {
 		return fallBack
 	}
@@ -67,61 +225,122 @@ func clientRegistrationIRI(self vocab.Actor) string {
 	if err != nil {
 		return fallBack
 	}
-	tokURL.Path = filepath.Join(filepath.Dir(tokURL.Path), "client")
+	tokURL.Path = filepath.Join(filepath.Dir(tokURL.Path), name)
 	return tokURL.String()
 }
 
-// HandleOAuthAuthorizationServer serves /.well-known/oauth-authorization-server
-func (h handler) HandleOAuthAuthorizationServer(w http.ResponseWriter, r *http.Request) {
+func clientRegistrationIRI(self vocab.Actor) string {
+	return oauthEndpointIRI(self, "client")
+}
+
+func authorizationEndpointIRI(self vocab.Actor) string {
+	if self.Endpoints != nil && !vocab.IsNil(self.Endpoints.OauthAuthorizationEndpoint) {
+		return self.Endpoints.OauthAuthorizationEndpoint.GetID().String()
+	}
+	return self.ID.AddPath("oauth/authorize").String()
+}
+
+func tokenEndpointIRI(self vocab.Actor) string {
+	if self.Endpoints != nil && !vocab.IsNil(self.Endpoints.OauthTokenEndpoint) {
+		return self.Endpoints.OauthTokenEndpoint.GetID().String()
+	}
+	return self.ID.AddPath("oauth/token").String()
+}
+
+// loadIssuer loads the actor that is the OAuth2 issuer corresponding to a request made to the wellKnownPath.
+func (h handler) loadIssuer(r *http.Request, wellKnownPath string) (*vocab.Actor, Storage, error) {
 	storage, err := h.findMatchingStorage(baseURL(r)...)
 	if err != nil {
-		handleErr(h.l)(r, err).ServeHTTP(w, r)
-		return
+		return nil, storage, err
 	}
-	maybeActor, err := LoadActor(storage, filters.SameID(issuerIRIFromOAuthAuthorizationRequest(r)))
+	if storage, err = h.authorizeRequest(r, storage); err != nil {
+		return nil, storage, err
+	}
+	maybeActor, err := LoadActor(storage, filters.SameID(issuerIRIFromWellKnownRequest(r, wellKnownPath)))
 	if err != nil {
-		handleErr(h.l)(r, errors.Annotatef(err, "unable to find actor")).ServeHTTP(w, r)
-		return
+		return nil, storage, errors.Annotatef(err, "unable to find actor")
 	}
 	if vocab.IsNil(maybeActor) || auth.AnonymousActor.Equals(maybeActor) {
-		handleErr(h.l)(r, errors.NotFoundf("issuer not found")).ServeHTTP(w, r)
-		return
+		return nil, storage, errors.NotFoundf("issuer not found")
 	}
 	// NOTE(marius): this implies that only actors can be OAuth2 issuers
 	self, err := vocab.ToActor(maybeActor)
 	if err != nil {
-		handleErr(h.l)(r, errors.Annotatef(err, "invalid type for issuer %T", maybeActor)).ServeHTTP(w, r)
-		return
+		return nil, storage, errors.Annotatef(err, "invalid type for issuer %T", maybeActor)
+	}
+	return self, storage, nil
+}
+
+// oauthOptions returns the OAuth2 options of the storage for the issuer, restricted to the capabilities
+// reported by the authorize service. When probing fails, the configured values are used.
+func (h handler) oauthOptions(r *http.Request, storage Storage, issuer vocab.IRI) OAuthOptions {
+	// NOTE(marius): the issuer values need to be resolved first, otherwise they would override the probed ones
+	o := storage.Options.OAuth.ForIssuer(issuer)
+	if o.Prober == nil {
+		return o
+	}
+	caps, err := o.Prober.Capabilities(r.Context())
+	if err != nil {
+		h.l.Warnf("Unable to load authorize service capabilities, using configured values: %s", err)
+		return o
+	}
+	return caps.Apply(o)
+}
+
+// oauthAuthorizationMetadata returns the metadata of the self issuer, the options are expected to be
+// already resolved for it, see oauthOptions.
+func oauthAuthorizationMetadata(self vocab.Actor, o OAuthOptions) OAuthAuthorizationMetadata {
+	// NOTE(marius): As the registration end-point, the support for ClientID Metadata Document is actually part of
+	// the git.sr.ht/~mariusor/authorize service, and the default "true" value might be invalid if the service
+	// is older than commit 72a4ad6fd74b58f8cf9961d380aab237e80219e8, or another implementation.
+	cimdSupported := true
+	if o.ClientIDMetadataDocument != nil {
+		cimdSupported = *o.ClientIDMetadataDocument
+	}
+	// NOTE(marius): an explicitly empty list of code challenge methods means PKCE is not advertised
+	codeChallengeMethods := o.CodeChallengeMethods
+	if codeChallengeMethods == nil {
+		codeChallengeMethods = []string{"S256"}
 	}
-	meta := OAuthAuthorizationMetadata{
+	grants := o.grantTypes()
+	return OAuthAuthorizationMetadata{
 		Issuer:                            string(self.ID),
-		GrantTypesSupported:               defaultGrantTypes(),
-		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic"},
-		TokenEndpointAuthSigningAlgValuesSupported: []string{},
-		ResponseTypesSupported:                     nil,
+		GrantTypesSupported:               grants,
+		TokenEndpointAuthMethodsSupported: valuesOrDefault(o.TokenEndpointAuthMethods, "client_secret_basic"),
+		TokenEndpointAuthSigningAlgValuesSupported: valuesOrDefault(o.TokenEndpointAuthSigningAlgs),
+		ResponseTypesSupported:                     responseTypes(grants),
 		// TODO(marius): find a way to unify the way we generate these two values
 		// NOTE(marius): This URL is not handled by us, as it's related to the OAuth2 authorization flow.
 		// It is exposed by the git.sr.ht/~mariusor/authorize service.
-		RegistrationEndpoint: clientRegistrationIRI(*self),
-		// NOTE(marius): As above, the support for ClientID Metadata Document is actually part of
-		// the git.sr.ht/~mariusor/authorize service, and this "true" value might be invalid if the service
-		// is older than commit 72a4ad6fd74b58f8cf9961d380aab237e80219e8, or another implementation.
-		ClientIDMetadataDocumentSupported: true,
-		CodeChallengeMethodsSupported:     []string{"S256"},
-	}
-	if self.Endpoints != nil {
-		if !vocab.IsNil(self.Endpoints.OauthAuthorizationEndpoint) {
-			meta.AuthorizationEndpoint = self.Endpoints.OauthAuthorizationEndpoint.GetID().String()
-		}
-		if !vocab.IsNil(self.Endpoints.OauthTokenEndpoint) {
-			meta.TokenEndpoint = self.Endpoints.OauthTokenEndpoint.GetID().String()
-		}
+		RegistrationEndpoint:              clientRegistrationIRI(self),
+		ClientIDMetadataDocumentSupported: cimdSupported,
+		CodeChallengeMethodsSupported:     codeChallengeMethods,
+		AuthorizationEndpoint:             authorizationEndpointIRI(self),
+		TokenEndpoint:                     tokenEndpointIRI(self),
+		JWKsURI:                           jwksIRI(self),
+		ScopesSupported:                   o.Scopes,
+		RevocationEndpoint:                o.RevocationEndpoint,
+		IntrospectionEndpoint:             o.IntrospectionEndpoint,
+		DPoPSigningAlgValuesSupported:     o.DPoPSigningAlgs,
+		ServiceDocumentation:              o.ServiceDocumentation,
 	}
-	if meta.AuthorizationEndpoint == "" {
-		meta.AuthorizationEndpoint = self.ID.AddPath("oauth/authorize").String()
+}
+
+// HandleOAuthAuthorizationServer serves /.well-known/oauth-authorization-server
+func (h handler) HandleOAuthAuthorizationServer(w http.ResponseWriter, r *http.Request) {
+	self, storage, err := h.loadIssuer(r, WellKnownOAuthAuthorizationServerPath)
+	if err != nil {
+		handleErr(h.l)(r, err).ServeHTTP(w, r)
+		return
 	}
-	if meta.TokenEndpoint == "" {
-		meta.TokenEndpoint = self.ID.AddPath("oauth/token").String()
+	o := h.oauthOptions(r, storage, self.ID)
+	meta := oauthAuthorizationMetadata(*self, o)
+	if key, kid, err := metadataSigningKey(storage, o); err == nil {
+		if meta.SignedMetadata, err = signMetadata(meta, key, kid); err != nil {
+			h.l.Warnf("Unable to sign the OAuth2 authorization server metadata: %s", err)
+		}
+	} else if !errors.IsNotFound(err) {
+		h.l.Warnf("Unable to load the OAuth2 authorization server metadata signing key: %s", err)
 	}
 	data, _ := json.Marshal(meta)
 
@@ -0,0 +1,148 @@
+package webfinger
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/openshift/osin"
+)
+
+func TestIssuerIRIFromWellKnownRequest(t *testing.T) {
+	tests := []struct {
+		name      string
+		uri       string
+		wellKnown string
+		want      vocab.IRI
+	}{
+		{
+			name:      "oauth root",
+			uri:       "/.well-known/oauth-authorization-server",
+			wellKnown: WellKnownOAuthAuthorizationServerPath,
+			want:      "https://example.com",
+		},
+		{
+			name:      "oauth inserted",
+			uri:       "/.well-known/oauth-authorization-server/actors/jdoe",
+			wellKnown: WellKnownOAuthAuthorizationServerPath,
+			want:      "https://example.com/actors/jdoe",
+		},
+		{
+			name:      "openid inserted",
+			uri:       "/.well-known/openid-configuration/actors/jdoe",
+			wellKnown: WellKnownOpenIDConfigurationPath,
+			want:      "https://example.com/actors/jdoe",
+		},
+		{
+			name:      "openid appended",
+			uri:       "/actors/jdoe/.well-known/openid-configuration",
+			wellKnown: WellKnownOpenIDConfigurationPath,
+			want:      "https://example.com/actors/jdoe",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.uri, nil)
+			if got := issuerIRIFromWellKnownRequest(r, tt.wellKnown); got != tt.want {
+				t.Errorf("issuerIRIFromWellKnownRequest() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOAuthAuthorizationMetadata(t *testing.T) {
+	self := vocab.Actor{ID: "https://example.com/actors/jdoe"}
+	noCIMD := false
+
+	t.Run("defaults", func(t *testing.T) {
+		meta := oauthAuthorizationMetadata(self, OAuthOptions{})
+		if len(meta.GrantTypesSupported) != len(DefaultAccessTypes) {
+			t.Errorf("Invalid grant_types_supported %v", meta.GrantTypesSupported)
+		}
+		if len(meta.ResponseTypesSupported) != 1 || meta.ResponseTypesSupported[0] != "code" {
+			t.Errorf("Invalid response_types_supported %v, expected [code]", meta.ResponseTypesSupported)
+		}
+		if !meta.ClientIDMetadataDocumentSupported {
+			t.Errorf("Invalid client_id_metadata_document_supported %t, expected true", meta.ClientIDMetadataDocumentSupported)
+		}
+		if len(meta.CodeChallengeMethodsSupported) != 1 || meta.CodeChallengeMethodsSupported[0] != "S256" {
+			t.Errorf("Invalid code_challenge_methods_supported %v", meta.CodeChallengeMethodsSupported)
+		}
+	})
+	t.Run("configured", func(t *testing.T) {
+		o := OAuthOptions{
+			GrantTypes:               []string{"implicit", "refresh_token"},
+			TokenEndpointAuthMethods: []string{"private_key_jwt"},
+			ClientIDMetadataDocument: &noCIMD,
+			RevocationEndpoint:       "https://example.com/oauth/revoke",
+			Scopes:                   []string{"read"},
+		}
+		meta := oauthAuthorizationMetadata(self, o)
+		if len(meta.ResponseTypesSupported) != 1 || meta.ResponseTypesSupported[0] != "token" {
+			t.Errorf("Invalid response_types_supported %v, expected [token]", meta.ResponseTypesSupported)
+		}
+		if meta.GrantTypesSupported[0] != "implicit" {
+			t.Errorf("Invalid grant_types_supported %v", meta.GrantTypesSupported)
+		}
+		if meta.ClientIDMetadataDocumentSupported {
+			t.Errorf("Invalid client_id_metadata_document_supported %t, expected false", meta.ClientIDMetadataDocumentSupported)
+		}
+		if meta.TokenEndpointAuthMethodsSupported[0] != "private_key_jwt" || meta.RevocationEndpoint == "" || len(meta.ScopesSupported) != 1 {
+			t.Errorf("Invalid configured values %+v", meta)
+		}
+	})
+	t.Run("per issuer", func(t *testing.T) {
+		o := OAuthOptions{
+			CodeChallengeMethods: []string{"S256"},
+			Issuers: map[vocab.IRI]OAuthOptions{
+				self.ID: {CodeChallengeMethods: []string{"S256", "plain"}},
+			},
+		}
+		meta := oauthAuthorizationMetadata(self, o.ForIssuer(self.ID))
+		if len(meta.CodeChallengeMethodsSupported) != 2 {
+			t.Errorf("Invalid code_challenge_methods_supported %v, expected the issuer values", meta.CodeChallengeMethodsSupported)
+		}
+		other := oauthAuthorizationMetadata(vocab.Actor{ID: "https://example.com"}, o.ForIssuer("https://example.com"))
+		if len(other.CodeChallengeMethodsSupported) != 1 {
+			t.Errorf("Invalid code_challenge_methods_supported %v, expected the storage values", other.CodeChallengeMethodsSupported)
+		}
+		// NOTE(marius): the options are used as they are, so the values restricted after resolving the issuer
+		// ones, like by the authorize service probe, are not overridden again.
+		resolved := oauthAuthorizationMetadata(self, o)
+		if len(resolved.CodeChallengeMethodsSupported) != 1 {
+			t.Errorf("Invalid code_challenge_methods_supported %v, expected the values that were passed", resolved.CodeChallengeMethodsSupported)
+		}
+	})
+	t.Run("empty code challenge methods", func(t *testing.T) {
+		o := OAuthOptions{
+			Issuers: map[vocab.IRI]OAuthOptions{
+				self.ID: {CodeChallengeMethods: []string{}},
+			},
+		}
+		meta := oauthAuthorizationMetadata(self, o.ForIssuer(self.ID))
+		if len(meta.CodeChallengeMethodsSupported) != 0 {
+			t.Errorf("Invalid code_challenge_methods_supported %v, expected none", meta.CodeChallengeMethodsSupported)
+		}
+		dat, _ := json.Marshal(meta)
+		if strings.Contains(string(dat), "code_challenge_methods_supported") {
+			t.Errorf("Invalid metadata %s, expected no code_challenge_methods_supported", dat)
+		}
+	})
+	t.Run("unknown grant types", func(t *testing.T) {
+		meta := oauthAuthorizationMetadata(self, OAuthOptions{GrantTypes: []string{"authorization_code", "magic"}})
+		if len(meta.GrantTypesSupported) != 1 || meta.GrantTypesSupported[0] != osin.AUTHORIZATION_CODE {
+			t.Errorf("Invalid grant_types_supported %v, expected [authorization_code]", meta.GrantTypesSupported)
+		}
+	})
+}
+
+func TestValidGrantTypes(t *testing.T) {
+	if err := ValidGrantTypes([]string{"authorization_code", "refresh_token", "implicit", "client_credentials"}); err != nil {
+		t.Errorf("Unexpected error for known grant types: %s", err)
+	}
+	if err := ValidGrantTypes([]string{"password", "magic"}); err == nil {
+		t.Errorf("Expected an error for the unknown grant type")
+	}
+}
@@ -0,0 +1,97 @@
+package webfinger
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+// OpenIDConfiguration is the metadata returned by the OpenID Connect discovery end-point.
+//
+// It extends the RFC8414 metadata with the values required by OpenID Connect.
+//
+// https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
+type OpenIDConfiguration struct {
+	OAuthAuthorizationMetadata
+	UserinfoEndpoint                 string   `json:"userinfo_endpoint"`
+	SubjectTypesSupported            []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+}
+
+const WellKnownOpenIDConfigurationPath = "/.well-known/openid-configuration"
+
+// OpenIDIssuerRel is the WebFinger link relation used for OpenID Connect issuer discovery.
+//
+// https://openid.net/specs/openid-connect-discovery-1_0.html#IssuerDiscovery
+const OpenIDIssuerRel = "http://openid.net/specs/connect/1.0/issuer"
+
+func hasOAuthEndpoints(act vocab.Actor) bool {
+	if act.Endpoints == nil {
+		return false
+	}
+	return !vocab.IsNil(act.Endpoints.OauthAuthorizationEndpoint) || !vocab.IsNil(act.Endpoints.OauthTokenEndpoint)
+}
+
+// openIDIssuerOf returns the IRI of the OAuth2 issuer for the actor, for which we serve the
+// authorization server metadata. The second return value is false when the actor has no OAuth2 endpoints.
+//
+// When the actor shares the authorization server of the service actor, the service is the issuer,
+// otherwise the actor itself is.
+func openIDIssuerOf(act, service vocab.Actor) (vocab.IRI, bool) {
+	if !hasOAuthEndpoints(act) {
+		return "", false
+	}
+	if service.ID != "" && hasOAuthEndpoints(service) &&
+		tokenEndpointIRI(act) == tokenEndpointIRI(service) &&
+		authorizationEndpointIRI(act) == authorizationEndpointIRI(service) {
+		return service.ID, true
+	}
+	return act.ID, true
+}
+
+// IsOpenIDConfigurationPath returns true for paths of OpenID Connect discovery documents of issuers
+// with a path component, where the well known path is appended after the issuer's path.
+func IsOpenIDConfigurationPath(p string) bool {
+	return strings.HasSuffix(p, WellKnownOpenIDConfigurationPath)
+}
+
+func openIDConfiguration(self vocab.Actor, o OAuthOptions) OpenIDConfiguration {
+	meta := OpenIDConfiguration{
+		OAuthAuthorizationMetadata: oauthAuthorizationMetadata(self, o),
+		UserinfoEndpoint:           oauthEndpointIRI(self, "userinfo"),
+		SubjectTypesSupported:      []string{"public"},
+		// NOTE(marius): RS256 is mandatory for OpenID Connect providers
+		IDTokenSigningAlgValuesSupported: []string{"RS256"},
+	}
+	if meta.ResponseTypesSupported == nil {
+		// NOTE(marius): response_types_supported is required by OpenID Connect discovery, so when
+		// no grant has a corresponding response type, we fall back to the authorization code one.
+		meta.ResponseTypesSupported = []string{"code"}
+	}
+	if pub, err := publicKeyOf(self); err == nil {
+		if alg := signingAlgOf(pub); alg != "" && alg != "RS256" {
+			meta.IDTokenSigningAlgValuesSupported = append(meta.IDTokenSigningAlgValuesSupported, alg)
+		}
+	}
+	return meta
+}
+
+// HandleOpenIDConfiguration serves /.well-known/openid-configuration
+//
+// The issuer is resolved in the same way as for HandleOAuthAuthorizationServer, with the addition of the
+// well known path being appended to the issuer IRI, as specified by OpenID Connect discovery.
+func (h handler) HandleOpenIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	self, storage, err := h.loadIssuer(r, WellKnownOpenIDConfigurationPath)
+	if err != nil {
+		handleErr(h.l)(r, err).ServeHTTP(w, r)
+		return
+	}
+	data, _ := json.Marshal(openIDConfiguration(*self, h.oauthOptions(r, storage, self.ID)))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+	h.l.Debugf("%s %s%s %d %s", r.Method, r.Host, r.RequestURI, http.StatusOK, http.StatusText(http.StatusOK))
+}
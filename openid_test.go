@@ -0,0 +1,71 @@
+package webfinger
+
+import (
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+func TestOpenIDConfiguration(t *testing.T) {
+	self := vocab.Actor{ID: "https://example.com/actors/jdoe"}
+	meta := openIDConfiguration(self, OAuthOptions{})
+	if meta.Issuer != string(self.ID) {
+		t.Errorf("Invalid issuer %s, expected %s", meta.Issuer, self.ID)
+	}
+	if want := "https://example.com/actors/jdoe/oauth/userinfo"; meta.UserinfoEndpoint != want {
+		t.Errorf("Invalid userinfo_endpoint %s, expected %s", meta.UserinfoEndpoint, want)
+	}
+	if want := "https://example.com/actors/jdoe/oauth/token"; meta.TokenEndpoint != want {
+		t.Errorf("Invalid token_endpoint %s, expected %s", meta.TokenEndpoint, want)
+	}
+	if len(meta.SubjectTypesSupported) == 0 || len(meta.IDTokenSigningAlgValuesSupported) == 0 || len(meta.ResponseTypesSupported) == 0 {
+		t.Errorf("Missing required OpenID Connect values: %+v", meta)
+	}
+}
+
+func TestOpenIDIssuerOf(t *testing.T) {
+	service := vocab.Actor{
+		ID: "https://example.com/",
+		Endpoints: &vocab.Endpoints{
+			OauthAuthorizationEndpoint: vocab.IRI("https://example.com/oauth/authorize"),
+			OauthTokenEndpoint:         vocab.IRI("https://example.com/oauth/token"),
+		},
+	}
+	tests := []struct {
+		name   string
+		actor  vocab.Actor
+		want   vocab.IRI
+		wantOk bool
+	}{
+		{
+			name:  "no endpoints",
+			actor: vocab.Actor{ID: "https://example.com/actors/jdoe"},
+		},
+		{
+			name:   "shared authorization server",
+			actor:  vocab.Actor{ID: "https://example.com/actors/jdoe", Endpoints: service.Endpoints},
+			want:   service.ID,
+			wantOk: true,
+		},
+		{
+			name: "own authorization server",
+			actor: vocab.Actor{
+				ID: "https://example.com/actors/jdoe",
+				Endpoints: &vocab.Endpoints{
+					OauthAuthorizationEndpoint: vocab.IRI("https://example.com/actors/jdoe/oauth/authorize"),
+					OauthTokenEndpoint:         vocab.IRI("https://example.com/actors/jdoe/oauth/token"),
+				},
+			},
+			want:   "https://example.com/actors/jdoe",
+			wantOk: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := openIDIssuerOf(tt.actor, service)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("openIDIssuerOf() = %s, %t, want %s, %t", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
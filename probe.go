@@ -0,0 +1,169 @@
+package webfinger
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-ap/errors"
+	"golang.org/x/sync/singleflight"
+)
+
+// DefaultProbeTTL is the duration for which the results of probing the authorize service are cached.
+const DefaultProbeTTL = 10 * time.Minute
+
+// DefaultProbeRetryTTL is the duration for which a failure to probe the authorize service is cached.
+const DefaultProbeRetryTTL = 30 * time.Second
+
+// DefaultProbeTimeout is the maximum duration of a request to the authorize service.
+const DefaultProbeTimeout = 5 * time.Second
+
+// AuthorizeCapabilities are the OAuth2 capabilities reported by the authorize service.
+//
+// A nil value means the service didn't report anything about that capability.
+type AuthorizeCapabilities struct {
+	GrantTypes               []string `json:"grant_types_supported"`
+	CodeChallengeMethods     []string `json:"code_challenge_methods_supported"`
+	TokenEndpointAuthMethods []string `json:"token_endpoint_auth_methods_supported"`
+	ClientIDMetadataDocument *bool    `json:"client_id_metadata_document_supported"`
+}
+
+// Apply returns the options restricted to the values reported by the authorize service.
+//
+// The configured values are intersected with the reported ones, so we advertise only what is both
+// enabled and supported, and when a value has not been configured the reported one is used.
+func (c AuthorizeCapabilities) Apply(o OAuthOptions) OAuthOptions {
+	o.GrantTypes = intersectValues(o.GrantTypes, c.GrantTypes)
+	o.CodeChallengeMethods = intersectValues(o.CodeChallengeMethods, c.CodeChallengeMethods)
+	o.TokenEndpointAuthMethods = intersectValues(o.TokenEndpointAuthMethods, c.TokenEndpointAuthMethods)
+	if c.ClientIDMetadataDocument != nil && (o.ClientIDMetadataDocument == nil || !*c.ClientIDMetadataDocument) {
+		o.ClientIDMetadataDocument = c.ClientIDMetadataDocument
+	}
+	return o
+}
+
+// intersectValues returns the configured values that are also in the supported ones.
+// A nil configured list returns the supported values, and a nil supported list returns the configured ones.
+func intersectValues(configured, supported []string) []string {
+	if supported == nil {
+		return configured
+	}
+	if configured == nil {
+		return supported
+	}
+	result := make([]string, 0, len(configured))
+	for _, v := range configured {
+		if slices.Contains(supported, v) {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// AuthorizeProber queries the authorize service for the OAuth2 capabilities it supports,
+// so we don't advertise features that the service is too old to have.
+//
+// It expects the URL to return a JSON document with the same properties as the RFC8414 metadata.
+// The results are cached for TTL, and the failures for RetryTTL.
+type AuthorizeProber struct {
+	URL      string
+	TTL      time.Duration
+	RetryTTL time.Duration
+	Client   *http.Client
+
+	group   singleflight.Group
+	mu      sync.Mutex
+	caps    AuthorizeCapabilities
+	err     error
+	fetched time.Time
+}
+
+// NewAuthorizeProber returns a prober for the authorize service capabilities document at url.
+func NewAuthorizeProber(url string, ttl time.Duration) *AuthorizeProber {
+	if ttl <= 0 {
+		ttl = DefaultProbeTTL
+	}
+	return &AuthorizeProber{
+		URL:      url,
+		TTL:      ttl,
+		RetryTTL: DefaultProbeRetryTTL,
+		Client:   &http.Client{Timeout: DefaultProbeTimeout},
+	}
+}
+
+func (p *AuthorizeProber) probe(ctx context.Context) (AuthorizeCapabilities, error) {
+	caps := AuthorizeCapabilities{}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL, nil)
+	if err != nil {
+		return caps, errors.Annotatef(err, "unable to build request for authorize service")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	cl := p.Client
+	if cl == nil {
+		cl = http.DefaultClient
+	}
+	resp, err := cl.Do(req)
+	if err != nil {
+		return caps, errors.Annotatef(err, "unable to probe authorize service")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return caps, errors.Newf("unable to probe authorize service: %s", resp.Status)
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&caps); err != nil {
+		return caps, errors.Annotatef(err, "invalid authorize service capabilities")
+	}
+	return caps, nil
+}
+
+// cached returns the result of the last probe, if it's not older than the TTL, or than the RetryTTL for failures.
+func (p *AuthorizeProber) cached() (AuthorizeCapabilities, error, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.fetched.IsZero() {
+		return p.caps, p.err, false
+	}
+	ttl := p.TTL
+	if p.err != nil {
+		retry := p.RetryTTL
+		if retry <= 0 {
+			retry = DefaultProbeRetryTTL
+		}
+		ttl = min(ttl, retry)
+	}
+	return p.caps, p.err, time.Since(p.fetched) < ttl
+}
+
+// Capabilities returns the capabilities reported by the authorize service, fetching them
+// when the cached ones are expired. Concurrent callers share the same fetch.
+func (p *AuthorizeProber) Capabilities(ctx context.Context) (AuthorizeCapabilities, error) {
+	if caps, err, ok := p.cached(); ok {
+		return caps, err
+	}
+	ch := p.group.DoChan(p.URL, func() (any, error) {
+		// NOTE(marius): the result is shared and cached, so the probe must not be canceled when
+		// the request that triggered it goes away.
+		pctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), DefaultProbeTimeout)
+		defer cancel()
+
+		caps, err := p.probe(pctx)
+		p.mu.Lock()
+		p.caps, p.err, p.fetched = caps, err, time.Now()
+		p.mu.Unlock()
+		return caps, err
+	})
+	select {
+	case res := <-ch:
+		caps, _ := res.Val.(AuthorizeCapabilities)
+		return caps, res.Err
+	case <-ctx.Done():
+		return AuthorizeCapabilities{}, ctx.Err()
+	}
+}
@@ -0,0 +1,181 @@
+package webfinger
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"git.sr.ht/~mariusor/lw"
+	vocab "github.com/go-ap/activitypub"
+)
+
+func TestAuthorizeProber_Capabilities(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"grant_types_supported":["authorization_code"],"code_challenge_methods_supported":["S256","plain"],"client_id_metadata_document_supported":false}`))
+	}))
+	defer srv.Close()
+
+	p := NewAuthorizeProber(srv.URL, time.Minute)
+	caps, err := p.Capabilities(context.Background())
+	if err != nil {
+		t.Fatalf("Capabilities() returned error %s", err)
+	}
+	if _, err = p.Capabilities(context.Background()); err != nil {
+		t.Fatalf("Capabilities() returned error %s", err)
+	}
+	if calls != 1 {
+		t.Errorf("Invalid number of calls to the authorize service %d, expected the result to be cached", calls)
+	}
+
+	o := caps.Apply(OAuthOptions{GrantTypes: []string{"password", "authorization_code"}, TokenEndpointAuthMethods: []string{"client_secret_post"}})
+	if len(o.GrantTypes) != 1 || o.GrantTypes[0] != "authorization_code" {
+		t.Errorf("Invalid grant types %v, expected the configured ones that were probed", o.GrantTypes)
+	}
+	if len(o.CodeChallengeMethods) != 2 {
+		t.Errorf("Invalid code challenge methods %v, expected the probed ones", o.CodeChallengeMethods)
+	}
+	if o.ClientIDMetadataDocument == nil || *o.ClientIDMetadataDocument {
+		t.Errorf("Invalid client ID metadata document support %v, expected false", o.ClientIDMetadataDocument)
+	}
+	if len(o.TokenEndpointAuthMethods) != 1 || o.TokenEndpointAuthMethods[0] != "client_secret_post" {
+		t.Errorf("Invalid token endpoint auth methods %v, expected the configured ones", o.TokenEndpointAuthMethods)
+	}
+}
+
+func TestAuthorizeProber_failure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	p := NewAuthorizeProber(srv.URL, time.Minute)
+	if _, err := p.Capabilities(context.Background()); err == nil {
+		t.Errorf("Capabilities() expected error for failing authorize service")
+	}
+
+	h := handler{l: lw.Dev(lw.SetLevel(lw.ErrorLevel))}
+	storage := Storage{Options: Options{OAuth: OAuthOptions{GrantTypes: []string{"password"}, Prober: p}}}
+	o := h.oauthOptions(httptest.NewRequest(http.MethodGet, WellKnownOAuthAuthorizationServerPath, nil), storage, "https://example.com")
+	if len(o.GrantTypes) != 1 || o.GrantTypes[0] != "password" {
+		t.Errorf("Invalid grant types %v, expected the configured ones", o.GrantTypes)
+	}
+}
+
+func TestAuthorizeProber_retry(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"grant_types_supported":["authorization_code"]}`))
+	}))
+	defer srv.Close()
+
+	p := NewAuthorizeProber(srv.URL, time.Hour)
+	p.RetryTTL = 10 * time.Millisecond
+	if _, err := p.Capabilities(context.Background()); err == nil {
+		t.Fatalf("Capabilities() expected error for failing authorize service")
+	}
+	if _, err := p.Capabilities(context.Background()); err == nil {
+		t.Errorf("Capabilities() expected the failure to be cached for the retry TTL")
+	}
+	time.Sleep(2 * p.RetryTTL)
+	caps, err := p.Capabilities(context.Background())
+	if err != nil {
+		t.Fatalf("Capabilities() returned error %s after the retry TTL", err)
+	}
+	if len(caps.GrantTypes) != 1 {
+		t.Errorf("Invalid grant types %v, expected the probed ones", caps.GrantTypes)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("Invalid number of calls to the authorize service %d, expected 2", calls.Load())
+	}
+}
+
+func TestAuthorizeProber_concurrent(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		_, _ = w.Write([]byte(`{"grant_types_supported":["authorization_code"]}`))
+	}))
+	defer srv.Close()
+
+	p := NewAuthorizeProber(srv.URL, time.Minute)
+
+	// NOTE(marius): the request that triggers the probe goes away, which must not fail the probe for the others
+	canceled, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := p.Capabilities(canceled)
+		first <- err
+	}()
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	wg := sync.WaitGroup{}
+	errs := make(chan error, 8)
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := p.Capabilities(context.Background())
+			errs <- err
+		}()
+	}
+	cancel()
+	if err := <-first; err == nil {
+		t.Errorf("Capabilities() expected error for the canceled request")
+	}
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Capabilities() returned error %s", err)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("Invalid number of calls to the authorize service %d, expected the concurrent probes to be shared", calls.Load())
+	}
+	if _, err := p.Capabilities(context.Background()); err != nil {
+		t.Errorf("Capabilities() returned error %s, expected the cached capabilities", err)
+	}
+}
+
+func TestHandler_oauthOptions_issuer(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"grant_types_supported":["authorization_code","refresh_token"],"code_challenge_methods_supported":["S256"]}`))
+	}))
+	defer srv.Close()
+
+	issuer := vocab.IRI("https://example.com/actors/app")
+	h := handler{l: lw.Dev(lw.SetLevel(lw.ErrorLevel))}
+	storage := Storage{Options: Options{OAuth: OAuthOptions{
+		Prober: NewAuthorizeProber(srv.URL, time.Minute),
+		Issuers: map[vocab.IRI]OAuthOptions{
+			issuer: {GrantTypes: []string{"client_credentials", "refresh_token"}, CodeChallengeMethods: []string{"S256", "plain"}},
+		},
+	}}}
+	o := h.oauthOptions(httptest.NewRequest(http.MethodGet, WellKnownOAuthAuthorizationServerPath, nil), storage, issuer)
+	if len(o.GrantTypes) != 1 || o.GrantTypes[0] != "refresh_token" {
+		t.Errorf("Invalid grant types %v, expected the issuer ones that were probed", o.GrantTypes)
+	}
+	if len(o.CodeChallengeMethods) != 1 || o.CodeChallengeMethods[0] != "S256" {
+		t.Errorf("Invalid code challenge methods %v, expected the issuer ones that were probed", o.CodeChallengeMethods)
+	}
+	meta := oauthAuthorizationMetadata(vocab.Actor{ID: issuer}, o)
+	if len(meta.GrantTypesSupported) != 1 || meta.GrantTypesSupported[0] != "refresh_token" {
+		t.Errorf("Invalid grant_types_supported %v, expected [refresh_token]", meta.GrantTypesSupported)
+	}
+}
@@ -0,0 +1,75 @@
+package webfinger
+
+import (
+	"encoding/json"
+	"net/http"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/filters"
+)
+
+// OAuthProtectedResourceMetadata is the metadata returned by the RFC9728 well known oauth-protected-resource end-point
+//
+// https://datatracker.ietf.org/doc/html/rfc9728#section-2
+type OAuthProtectedResourceMetadata struct {
+	Resource               string   `json:"resource"`
+	AuthorizationServers   []string `json:"authorization_servers"`
+	JWKsURI                string   `json:"jwks_uri,omitempty"`
+	ScopesSupported        []string `json:"scopes_supported"`
+	BearerMethodsSupported []string `json:"bearer_methods_supported"`
+	ResourceName           string   `json:"resource_name,omitempty"`
+}
+
+const WellKnownOAuthProtectedResourcePath = "/.well-known/oauth-protected-resource"
+
+// defaultBearerMethods are the ways in which the FedBOX instances accept bearer tokens.
+var defaultBearerMethods = []string{"header"}
+
+func oauthProtectedResourceMetadata(resource vocab.IRI, db Storage) OAuthProtectedResourceMetadata {
+	scopes := db.Options.OAuth.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	// NOTE(marius): the storage's root actor is the issuer for all the resources it contains.
+	return OAuthProtectedResourceMetadata{
+		Resource:               string(resource),
+		AuthorizationServers:   []string{string(db.Root.ID)},
+		JWKsURI:                jwksIRI(db.Root),
+		ScopesSupported:        scopes,
+		BearerMethodsSupported: defaultBearerMethods,
+		ResourceName:           vocab.NameOf(db.Root),
+	}
+}
+
+// HandleOAuthProtectedResource serves /.well-known/oauth-protected-resource
+//
+// Similarly to the RFC8414 metadata, the path of the resource gets appended to the well known path.
+func (h handler) HandleOAuthProtectedResource(w http.ResponseWriter, r *http.Request) {
+	storage, err := h.findMatchingStorage(baseURL(r)...)
+	if err != nil {
+		handleErr(h.l)(r, err).ServeHTTP(w, r)
+		return
+	}
+	if storage, err = h.authorizeRequest(r, storage); err != nil {
+		handleErr(h.l)(r, err).ServeHTTP(w, r)
+		return
+	}
+
+	resource := issuerIRIFromWellKnownRequest(r, WellKnownOAuthProtectedResourcePath)
+	if !resource.Equals(baseIRI(resource), true) {
+		// NOTE(marius): the resource is loaded with the requester's visibility, so the response doesn't
+		// disclose the existence of the private ones
+		it, err := LoadIRI(storage, resource, filters.SameID(resource))
+		if err != nil || vocab.IsNil(it) {
+			handleErr(h.l)(r, errors.NewNotFound(err, "resource not found %s", resource)).ServeHTTP(w, r)
+			return
+		}
+	}
+	data, _ := json.Marshal(oauthProtectedResourceMetadata(resource, storage))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+	h.l.Debugf("%s %s%s %d %s", r.Method, r.Host, r.RequestURI, http.StatusOK, http.StatusText(http.StatusOK))
+}
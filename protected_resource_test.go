@@ -0,0 +1,54 @@
+package webfinger
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"git.sr.ht/~mariusor/lw"
+	vocab "github.com/go-ap/activitypub"
+)
+
+func TestHandler_HandleOAuthProtectedResource(t *testing.T) {
+	root := itemFromJSON(t, `{"id":"https://example.com","type":"Application"}`)
+	db := newMockStore(root,
+		itemFromJSON(t, `{"id":"https://example.com/objects/public","type":"Note","to":["https://www.w3.org/ns/activitystreams#Public"]}`),
+		itemFromJSON(t, `{"id":"https://example.com/objects/private","type":"Note","to":["https://example.com/actors/jdoe"]}`),
+	)
+	h := handler{s: []Store{WithOptions(db, Options{Signatures: SignatureOptions{Verify: true}})}, l: lw.Dev(lw.SetLevel(lw.ErrorLevel))}
+
+	tests := map[string]int{
+		"/objects/public":  http.StatusOK,
+		"/objects/private": http.StatusNotFound,
+		"/objects/missing": http.StatusNotFound,
+	}
+	for path, want := range tests {
+		r := httptest.NewRequest(http.MethodGet, WellKnownOAuthProtectedResourcePath+path, nil)
+		r.Host = "example.com"
+		w := httptest.NewRecorder()
+		h.HandleOAuthProtectedResource(w, r)
+		if w.Code != want {
+			t.Errorf("HandleOAuthProtectedResource(%s) status %d, expected %d", path, w.Code, want)
+		}
+	}
+}
+
+func TestOAuthProtectedResourceMetadata(t *testing.T) {
+	db := Storage{
+		Root:    vocab.Actor{ID: "https://example.com"},
+		Options: Options{OAuth: OAuthOptions{Scopes: []string{"read", "write"}}},
+	}
+	meta := oauthProtectedResourceMetadata("https://example.com/actors/jdoe/inbox", db)
+	if meta.Resource != "https://example.com/actors/jdoe/inbox" {
+		t.Errorf("Invalid resource %s", meta.Resource)
+	}
+	if len(meta.AuthorizationServers) != 1 || meta.AuthorizationServers[0] != "https://example.com" {
+		t.Errorf("Invalid authorization_servers %v, expected the root actor", meta.AuthorizationServers)
+	}
+	if len(meta.ScopesSupported) != 2 {
+		t.Errorf("Invalid scopes_supported %v", meta.ScopesSupported)
+	}
+	if len(meta.BearerMethodsSupported) == 0 {
+		t.Errorf("Missing bearer_methods_supported")
+	}
+}
@@ -0,0 +1,287 @@
+package webfinger
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/filters"
+	"golang.org/x/sync/singleflight"
+)
+
+const WebFingerSearchPath = "/webfinger/search"
+
+// DefaultSearchIndexTTL is the duration after which the actor index gets rebuilt from the storage.
+const DefaultSearchIndexTTL = time.Minute
+
+const (
+	defaultSearchLimit = 10
+	maxSearchLimit     = 40
+)
+
+// The ranks of the matches, the lower ones are returned first.
+const (
+	rankUsernameExact = iota
+	rankUsername
+	rankName
+	rankNameWord
+)
+
+type indexedActor struct {
+	actor    vocab.Item
+	username string
+	name     string
+}
+
+type indexTerm struct {
+	key  string
+	rank int
+	pos  int
+}
+
+// actorIndex is an immutable snapshot of the local actors, with their terms sorted for prefix lookups.
+type actorIndex struct {
+	actors []indexedActor
+	terms  []indexTerm
+}
+
+func newActorIndex(all []indexedActor) *actorIndex {
+	idx := actorIndex{actors: all, terms: make([]indexTerm, 0, 2*len(all))}
+	for pos, a := range all {
+		if a.username != "" {
+			idx.terms = append(idx.terms, indexTerm{key: strings.ToLower(a.username), rank: rankUsername, pos: pos})
+		}
+		words := strings.Fields(strings.ToLower(a.name))
+		if len(words) == 0 {
+			continue
+		}
+		idx.terms = append(idx.terms, indexTerm{key: strings.Join(words, " "), rank: rankName, pos: pos})
+		// NOTE(marius): the first word is already matched by the full name
+		for _, word := range words[1:] {
+			idx.terms = append(idx.terms, indexTerm{key: word, rank: rankNameWord, pos: pos})
+		}
+	}
+	sort.Slice(idx.terms, func(i, j int) bool {
+		return idx.terms[i].key < idx.terms[j].key
+	})
+	return &idx
+}
+
+// search returns up to limit actors having a term starting with prefix, ordered by their rank,
+// and skipping the ones for which visible returns false.
+func (idx *actorIndex) search(prefix string, limit int, visible func(vocab.Item) bool) []indexedActor {
+	prefix = strings.ToLower(prefix)
+	ranks := make(map[int]int)
+	start := sort.Search(len(idx.terms), func(i int) bool {
+		return idx.terms[i].key >= prefix
+	})
+	for _, t := range idx.terms[start:] {
+		if !strings.HasPrefix(t.key, prefix) {
+			break
+		}
+		rank := t.rank
+		if rank == rankUsername && t.key == prefix {
+			rank = rankUsernameExact
+		}
+		if r, ok := ranks[t.pos]; !ok || rank < r {
+			ranks[t.pos] = rank
+		}
+	}
+
+	positions := make([]int, 0, len(ranks))
+	for pos := range ranks {
+		positions = append(positions, pos)
+	}
+	slices.SortFunc(positions, func(pa, pb int) int {
+		if ranks[pa] != ranks[pb] {
+			return ranks[pa] - ranks[pb]
+		}
+		a, b := idx.actors[pa], idx.actors[pb]
+		if len(a.username) != len(b.username) {
+			return len(a.username) - len(b.username)
+		}
+		return strings.Compare(a.username, b.username)
+	})
+
+	result := make([]indexedActor, 0, min(limit, len(positions)))
+	for _, pos := range positions {
+		if len(result) >= limit {
+			break
+		}
+		if a := idx.actors[pos]; visible(a.actor) {
+			result = append(result, a)
+		}
+	}
+	return result
+}
+
+// ActorIndex is an in memory prefix index over the preferredUsername and name of the local actors,
+// used for the handle search. It gets rebuilt from the storage when it's older than TTL.
+type ActorIndex struct {
+	TTL time.Duration
+
+	current atomic.Pointer[builtIndex]
+	group   singleflight.Group
+}
+
+// builtIndex is an index snapshot, together with the time it was built.
+type builtIndex struct {
+	idx   *actorIndex
+	built time.Time
+}
+
+// NewActorIndex returns an empty actor index, which gets loaded at the first search.
+func NewActorIndex(ttl time.Duration) *ActorIndex {
+	if ttl <= 0 {
+		ttl = DefaultSearchIndexTTL
+	}
+	return &ActorIndex{TTL: ttl}
+}
+
+func loadIndexedActors(storage Storage) ([]indexedActor, error) {
+	all, err := storage.Load(actors.IRI(storage.Root))
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to load actors")
+	}
+	items := make(vocab.ItemCollection, 0)
+	if vocab.IsCollection(all) {
+		_ = vocab.OnCollectionIntf(all, func(col vocab.CollectionInterface) error {
+			items = append(items, col.Collection()...)
+			return nil
+		})
+	}
+	if storage.Root.ID != "" && !items.Contains(storage.Root.ID) {
+		items = append(items, storage.Root)
+	}
+
+	result := make([]indexedActor, 0, len(items))
+	for _, it := range items {
+		if !filters.HasType(ValidActorTypes...).Match(it) {
+			continue
+		}
+		result = append(result, indexedActor{
+			actor:    it,
+			username: vocab.PreferredNameOf(it),
+			name:     vocab.NameOf(it),
+		})
+	}
+	return result, nil
+}
+
+// load returns the current index snapshot, rebuilding it from the storage when it's older than the TTL.
+//
+// The concurrent callers share the same rebuild, and the searches using the previous snapshot are not blocked
+// by it. When the rebuild fails, the stale snapshot is returned, if there is one.
+func (i *ActorIndex) load(storage Storage) (*actorIndex, error) {
+	cur := i.current.Load()
+	if cur != nil && time.Since(cur.built) < i.TTL {
+		return cur.idx, nil
+	}
+	idx, err, _ := i.group.Do("", func() (any, error) {
+		all, err := loadIndexedActors(storage)
+		if err != nil {
+			return nil, err
+		}
+		b := builtIndex{idx: newActorIndex(all), built: time.Now()}
+		i.current.Store(&b)
+		return b.idx, nil
+	})
+	if err != nil {
+		if cur != nil {
+			return cur.idx, nil
+		}
+		return nil, err
+	}
+	return idx.(*actorIndex), nil
+}
+
+// searchPrefix returns the username part of a search query, which can be a bare or an @ prefixed handle.
+func searchPrefix(q string) string {
+	q = strings.TrimPrefix(strings.TrimSpace(q), "@")
+	if user, _, ok := strings.Cut(q, "@"); ok {
+		return user
+	}
+	return q
+}
+
+// compactJRD returns the JRD document for a search hit, containing only the subject, the id and the self link.
+func compactJRD(a indexedActor, domain string) node {
+	id := a.actor.GetLink()
+	self := link{
+		Rel:  "self",
+		Type: "application/activity+json",
+		Href: id.String(),
+	}
+	if typ := a.actor.GetType(); typ != "" {
+		self.Properties = map[string]string{propertyActorType: string(typ)}
+	}
+	subject := accountURIOf(a.username, id, domain)
+	if subject == "" {
+		subject = id.String()
+	}
+	return node{
+		Subject: subject,
+		Aliases: []string{id.String()},
+		Links:   []link{self},
+	}
+}
+
+// HandleWebFingerSearch serves the prefix search over the handles and names of the local actors,
+// returning the compact JRD documents of the ones visible to the requester.
+func (h handler) HandleWebFingerSearch(w http.ResponseWriter, r *http.Request) {
+	storage, err := h.findMatchingStorage(baseURL(r)...)
+	if err != nil {
+		handleErr(h.l)(r, err).ServeHTTP(w, r)
+		return
+	}
+	if storage, err = h.authorizeRequest(r, storage); err != nil {
+		handleErr(h.l)(r, err).ServeHTTP(w, r)
+		return
+	}
+	index := storage.Options.WebFinger.SearchIndex
+	if index == nil {
+		handleErr(h.l)(r, errors.NotFoundf("search is not enabled")).ServeHTTP(w, r)
+		return
+	}
+
+	q := r.URL.Query()
+	prefix := searchPrefix(q.Get("q"))
+	if prefix == "" {
+		handleErr(h.l)(r, errors.BadRequestf("missing search query")).ServeHTTP(w, r)
+		return
+	}
+	limit := defaultSearchLimit
+	if l, err := strconv.Atoi(q.Get("limit")); err == nil && l > 0 {
+		limit = min(l, maxSearchLimit)
+	}
+
+	idx, err := index.load(storage)
+	if err != nil {
+		handleErr(h.l)(r, err).ServeHTTP(w, r)
+		return
+	}
+	// NOTE(marius): the search is for anonymous users too, so without a verified requester,
+	// the results are restricted to the public actors even when the signatures are not verified
+	requester := storage.Requester
+	if requester == "" {
+		requester = vocab.PublicNS
+	}
+	visible := filters.Authorized(requester).Match
+	results := make([]node, 0, limit)
+	for _, a := range idx.search(prefix, limit, visible) {
+		results = append(results, compactJRD(a, storage.Options.WebFinger.AccountDomain))
+	}
+
+	dat, _ := json.Marshal(results)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(dat)
+	h.l.Debugf("%s %s%s %d %s", r.Method, r.Host, r.RequestURI, http.StatusOK, http.StatusText(http.StatusOK))
+}
@@ -0,0 +1,228 @@
+package webfinger
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"git.sr.ht/~mariusor/lw"
+	vocab "github.com/go-ap/activitypub"
+)
+
+func mockIndexedActors() []indexedActor {
+	actor := func(id, typ, username, name string) indexedActor {
+		return indexedActor{
+			actor:    &vocab.Actor{ID: vocab.IRI(id), Type: vocab.ActivityVocabularyType(typ)},
+			username: username,
+			name:     name,
+		}
+	}
+	return []indexedActor{
+		actor("https://example.com/actors/annabel", "Person", "annabel", "Annabel Lee"),
+		actor("https://example.com/actors/ann", "Person", "ann", "Ann"),
+		actor("https://example.com/actors/jdoe", "Person", "jdoe", "John Annan"),
+		actor("https://example.com/actors/anna", "Person", "anna", ""),
+		actor("https://example.com/actors/bob", "Person", "bob", "Anders Bob"),
+		actor("https://example.com/actors/secret", "Person", "annsecret", ""),
+	}
+}
+
+func usernamesOf(hits []indexedActor) []string {
+	names := make([]string, 0, len(hits))
+	for _, h := range hits {
+		names = append(names, h.username)
+	}
+	return names
+}
+
+func TestActorIndex_search(t *testing.T) {
+	idx := newActorIndex(mockIndexedActors())
+	all := func(vocab.Item) bool { return true }
+	tests := []struct {
+		prefix  string
+		limit   int
+		visible func(vocab.Item) bool
+		want    []string
+	}{
+		{prefix: "ann", limit: 10, visible: all, want: []string{"ann", "anna", "annabel", "annsecret", "jdoe"}},
+		{prefix: "ANN", limit: 2, visible: all, want: []string{"ann", "anna"}},
+		{prefix: "an", limit: 10, visible: all, want: []string{"ann", "anna", "annabel", "annsecret", "bob", "jdoe"}},
+		{prefix: "lee", limit: 10, visible: all, want: []string{"annabel"}},
+		{prefix: "annabel l", limit: 10, visible: all, want: []string{"annabel"}},
+		{prefix: "zed", limit: 10, visible: all, want: []string{}},
+		{
+			prefix: "ann",
+			limit:  4,
+			visible: func(it vocab.Item) bool {
+				return it.GetLink() != "https://example.com/actors/secret"
+			},
+			want: []string{"ann", "anna", "annabel", "jdoe"},
+		},
+	}
+	for _, tt := range tests {
+		got := usernamesOf(idx.search(tt.prefix, tt.limit, tt.visible))
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("search(%q, %d) = %v, want %v", tt.prefix, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestActorIndex_load(t *testing.T) {
+	root := itemFromJSON(t, `{"id":"https://example.com","type":"Application"}`)
+	db := newMockStore(root)
+	storage := Storage{Store: db, Root: vocab.Actor{ID: "https://example.com", Type: vocab.ApplicationType}}
+	iri := actors.IRI(storage.Root)
+	index := NewActorIndex(time.Hour)
+	for range 3 {
+		idx, err := index.load(storage)
+		if err != nil {
+			t.Fatalf("load() error: %s", err)
+		}
+		if len(idx.actors) != 1 || idx.actors[0].actor.GetLink() != storage.Root.ID {
+			t.Errorf("Invalid indexed actors %v, expected only the root actor", idx.actors)
+		}
+	}
+	if loads := db.loadsOf(iri); loads != 1 {
+		t.Errorf("The index was built %d times, expected %d", loads, 1)
+	}
+	expire(index)
+	_, _ = index.load(storage)
+	if loads := db.loadsOf(iri); loads != 2 {
+		t.Errorf("The stale index should be rebuilt, got %d loads, expected %d", loads, 2)
+	}
+
+	// NOTE(marius): the store without the actors collection fails the rebuild
+	expire(index)
+	broken := Storage{Store: &mockStore{items: map[vocab.IRI]vocab.Item{}, loads: map[vocab.IRI]int{}}, Root: storage.Root}
+	idx, err := index.load(broken)
+	if err != nil {
+		t.Fatalf("load() error: %s, expected the stale index", err)
+	}
+	if len(idx.actors) != 1 {
+		t.Errorf("Invalid indexed actors %v, expected the stale index", idx.actors)
+	}
+	if _, err = NewActorIndex(time.Hour).load(broken); err == nil {
+		t.Errorf("load() should fail when there is no index to fall back to")
+	}
+}
+
+// expire marks the current snapshot of the index as being older than its TTL.
+func expire(index *ActorIndex) {
+	cur := index.current.Load()
+	index.current.Store(&builtIndex{idx: cur.idx, built: cur.built.Add(-2 * index.TTL)})
+}
+
+func TestActorIndex_loadConcurrent(t *testing.T) {
+	root := itemFromJSON(t, `{"id":"https://example.com","type":"Application"}`)
+	db := newMockStore(root, itemFromJSON(t, `{"id":"https://example.com/actors/jdoe","type":"Person","preferredUsername":"jdoe"}`))
+	storage := Storage{Store: db, Root: vocab.Actor{ID: "https://example.com", Type: vocab.ApplicationType}}
+	index := NewActorIndex(time.Hour)
+
+	wg := sync.WaitGroup{}
+	for range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			idx, err := index.load(storage)
+			if err != nil {
+				t.Errorf("load() error: %s", err)
+				return
+			}
+			if hits := idx.search("jd", 10, func(vocab.Item) bool { return true }); len(hits) != 1 {
+				t.Errorf("Invalid search results %v", hits)
+			}
+		}()
+	}
+	wg.Wait()
+	if loads := db.loadsOf(actors.IRI(storage.Root)); loads < 1 || loads > 16 {
+		t.Errorf("Invalid number of index builds %d", loads)
+	}
+}
+
+func TestHandler_HandleWebFingerSearch(t *testing.T) {
+	root := itemFromJSON(t, `{"id":"https://example.com","type":"Application"}`)
+	db := newMockStore(root,
+		itemFromJSON(t, `{
+			"id": "https://example.com/actors/ann",
+			"type": "Person",
+			"preferredUsername": "ann",
+			"attributedTo": "https://example.com",
+			"to": ["https://www.w3.org/ns/activitystreams#Public"]
+		}`),
+		itemFromJSON(t, `{
+			"id": "https://example.com/actors/annsecret",
+			"type": "Person",
+			"preferredUsername": "annsecret",
+			"attributedTo": "https://example.com",
+			"to": ["https://example.com/actors/ann"]
+		}`),
+	)
+
+	for _, verify := range []bool{false, true} {
+		o := Options{
+			WebFinger:  WebFingerOptions{SearchIndex: NewActorIndex(time.Hour)},
+			Signatures: SignatureOptions{Verify: verify},
+		}
+		h := handler{s: []Store{WithOptions(db, o)}, l: lw.Dev(lw.SetLevel(lw.ErrorLevel))}
+
+		r := httptest.NewRequest(http.MethodGet, WebFingerSearchPath+"?q=ann", nil)
+		r.Host = "example.com"
+		w := httptest.NewRecorder()
+		h.HandleWebFingerSearch(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("HandleWebFingerSearch() with verification %t status %d, expected %d", verify, w.Code, http.StatusOK)
+		}
+		results := make([]node, 0)
+		if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+			t.Fatalf("invalid search results %s: %s", w.Body.Bytes(), err)
+		}
+		if len(results) != 1 || results[0].Subject != "acct:ann@example.com" {
+			t.Errorf("Invalid search results %+v with verification %t, expected only the public actor", results, verify)
+		}
+	}
+
+	h := handler{s: []Store{WithOptions(db, Options{})}, l: lw.Dev(lw.SetLevel(lw.ErrorLevel))}
+	r := httptest.NewRequest(http.MethodGet, WebFingerSearchPath+"?q=ann", nil)
+	r.Host = "example.com"
+	w := httptest.NewRecorder()
+	h.HandleWebFingerSearch(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("HandleWebFingerSearch() status %d, expected %d when the search is not enabled", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestSearchPrefix(t *testing.T) {
+	tests := map[string]string{
+		"ann":              "ann",
+		" @ann ":           "ann",
+		"@ann@example.com": "ann",
+		"ann@":             "ann",
+		"@":                "",
+	}
+	for q, want := range tests {
+		if got := searchPrefix(q); got != want {
+			t.Errorf("searchPrefix(%q) = %q, want %q", q, got, want)
+		}
+	}
+}
+
+func TestCompactJRD(t *testing.T) {
+	a := mockIndexedActors()[0]
+	wf := compactJRD(a, "")
+	if wf.Subject != "acct:annabel@example.com" {
+		t.Errorf("Invalid subject %q, expected %q", wf.Subject, "acct:annabel@example.com")
+	}
+	if len(wf.Aliases) != 1 || wf.Aliases[0] != "https://example.com/actors/annabel" {
+		t.Errorf("Invalid aliases %v", wf.Aliases)
+	}
+	if len(wf.Links) != 1 || wf.Links[0].Rel != "self" || wf.Links[0].Properties[propertyActorType] != "Person" {
+		t.Errorf("Invalid links %+v", wf.Links)
+	}
+	if wf = compactJRD(a, "social.example.com"); wf.Subject != "acct:annabel@social.example.com" {
+		t.Errorf("Invalid subject %q, expected %q", wf.Subject, "acct:annabel@social.example.com")
+	}
+}
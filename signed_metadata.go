@@ -0,0 +1,110 @@
+package webfinger
+
+import (
+	"crypto"
+	"encoding/json"
+	"os"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// KeyLoader is implemented by the storage backends that can load the private keys of their actors.
+type KeyLoader interface {
+	LoadKey(vocab.IRI) (crypto.PrivateKey, error)
+}
+
+func keyLoaderOf(db Store) (KeyLoader, bool) {
+	if c, ok := db.(configuredStore); ok {
+		db = c.Store
+	}
+	kl, ok := db.(KeyLoader)
+	return kl, ok
+}
+
+// LoadSigningKey loads a PEM encoded private key from path, to be used as a metadata signing key.
+func LoadSigningKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to read signing key %s", path)
+	}
+	return privateKeyFromPEM(data)
+}
+
+// metadataSigningKey returns the key used for signing the metadata, together with its key ID.
+// The configured key takes precedence, and when it's missing, we try to load the private key
+// of the service actor from the storage.
+func metadataSigningKey(storage Storage, o OAuthOptions) (crypto.Signer, string, error) {
+	if !o.SignMetadata {
+		return nil, "", errors.NotFoundf("metadata signing is not enabled")
+	}
+	if o.SigningKey != nil {
+		jwk, err := JWKOf("", o.SigningKey.Public())
+		if err != nil {
+			return nil, "", err
+		}
+		return o.SigningKey, jwk.Kid, nil
+	}
+
+	kl, ok := keyLoaderOf(storage.Store)
+	if !ok {
+		return nil, "", errors.Newf("storage %T can not load the service actor key", storage.Store)
+	}
+	prv, err := kl.LoadKey(storage.Root.ID)
+	if err != nil {
+		return nil, "", errors.Annotatef(err, "unable to load the service actor key")
+	}
+	key, ok := prv.(crypto.Signer)
+	if !ok {
+		return nil, "", errors.Newf("unsupported private key type %T", prv)
+	}
+	kid := string(storage.Root.PublicKey.ID)
+	if kid == "" {
+		jwk, err := JWKOf("", key.Public())
+		if err != nil {
+			return nil, "", err
+		}
+		kid = jwk.Kid
+	}
+	return key, kid, nil
+}
+
+// signMetadata returns the RFC8414 signed_metadata JWT for the metadata.
+//
+// https://datatracker.ietf.org/doc/html/rfc8414#section-2.1
+func signMetadata(meta OAuthAuthorizationMetadata, key crypto.Signer, kid string) (string, error) {
+	meta.SignedMetadata = ""
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return "", err
+	}
+	claims := make(map[string]any)
+	if err = json.Unmarshal(data, &claims); err != nil {
+		return "", err
+	}
+	// NOTE(marius): the "iss" claim is the party attesting the metadata values, which is the issuer itself
+	claims["iss"] = meta.Issuer
+	return signJWT(claims, key, kid)
+}
+
+// VerifySignedMetadata checks the signature of the RFC8414 signed_metadata JWT with the public key,
+// and returns the metadata values it contains.
+//
+// As per the RFC, the returned values take precedence over the ones in the plain JSON document.
+func VerifySignedMetadata(token string, pub crypto.PublicKey) (*OAuthAuthorizationMetadata, error) {
+	payload, err := verifyJWT(token, pub)
+	if err != nil {
+		return nil, err
+	}
+	claims := struct {
+		OAuthAuthorizationMetadata
+		Iss string `json:"iss"`
+	}{}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.Annotatef(err, "invalid signed metadata claims")
+	}
+	if claims.Iss == "" {
+		return nil, errors.Newf("signed metadata is missing the iss claim")
+	}
+	return &claims.OAuthAuthorizationMetadata, nil
+}
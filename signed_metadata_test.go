@@ -0,0 +1,89 @@
+package webfinger
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"strings"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+func TestSignMetadata(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unable to generate RSA key: %s", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate Ed25519 key: %s", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate ECDSA key: %s", err)
+	}
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	meta := oauthAuthorizationMetadata(vocab.Actor{ID: "https://example.com/actors/jdoe"}, OAuthOptions{Scopes: []string{"read"}})
+
+	for name, key := range map[string]crypto.Signer{"RS256": rsaKey, "EdDSA": edKey, "ES384": ecKey} {
+		t.Run(name, func(t *testing.T) {
+			token, err := signMetadata(meta, key, "https://example.com/actors/jdoe#main-key")
+			if err != nil {
+				t.Fatalf("signMetadata() returned error %s", err)
+			}
+			got, err := VerifySignedMetadata(token, key.Public())
+			if err != nil {
+				t.Fatalf("VerifySignedMetadata() returned error %s", err)
+			}
+			if got.Issuer != meta.Issuer || got.TokenEndpoint != meta.TokenEndpoint || len(got.ScopesSupported) != 1 {
+				t.Errorf("Invalid signed metadata %+v, expected %+v", got, meta)
+			}
+
+			if _, err = VerifySignedMetadata(token, otherKey.Public()); err == nil {
+				t.Errorf("VerifySignedMetadata() should fail for a different key")
+			}
+			parts := strings.Split(token, ".")
+			tampered := meta
+			tampered.TokenEndpoint = "https://evil.example.com/token"
+			forged, _ := signMetadata(tampered, otherKey, "")
+			parts[1] = strings.Split(forged, ".")[1]
+			if _, err = VerifySignedMetadata(strings.Join(parts, "."), key.Public()); err == nil {
+				t.Errorf("VerifySignedMetadata() should fail for a tampered payload")
+			}
+		})
+	}
+}
+
+func TestMetadataSigningKey(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+
+	if _, _, err := metadataSigningKey(Storage{}, OAuthOptions{SigningKey: key}); !errors.IsNotFound(err) {
+		t.Errorf("metadataSigningKey() should return a not found error when signing is not enabled, got %v", err)
+	}
+	signer, kid, err := metadataSigningKey(Storage{}, OAuthOptions{SignMetadata: true, SigningKey: key})
+	if err != nil {
+		t.Fatalf("metadataSigningKey() returned error %s", err)
+	}
+	if !key.Equal(signer) {
+		t.Errorf("metadataSigningKey() should return the configured key")
+	}
+	jwk, _ := JWKOf("", key.Public())
+	if kid != jwk.Kid {
+		t.Errorf("Invalid kid %q, expected the key thumbprint %q", kid, jwk.Kid)
+	}
+	if _, _, err = metadataSigningKey(Storage{}, OAuthOptions{SignMetadata: true}); err == nil {
+		t.Errorf("metadataSigningKey() should fail for storage that can't load keys")
+	}
+
+	issuer := vocab.IRI("https://example.com/actors/jdoe")
+	o := OAuthOptions{Issuers: map[vocab.IRI]OAuthOptions{issuer: {SigningKey: key}}}.ForIssuer(issuer)
+	if !o.SignMetadata || o.SigningKey == nil {
+		t.Errorf("ForIssuer() should enable signing with the issuer key")
+	}
+}
@@ -1,13 +1,26 @@
 // Package webfinger
 package webfinger
 
-import "strings"
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
 
 type link struct {
-	Rel      string `json:"rel,omitempty"`
-	Type     string `json:"type,omitempty"`
-	Href     string `json:"href,omitempty"`
-	Template string `json:"template,omitempty"`
+	Rel        string            `json:"rel,omitempty"`
+	Type       string            `json:"type,omitempty"`
+	Href       string            `json:"href,omitempty"`
+	Template   string            `json:"template,omitempty"`
+	Properties map[string]string `json:"properties,omitempty"`
 }
 
 type node struct {
@@ -27,8 +40,160 @@ func splitResourceString(res string) (string, string) {
 	}
 	typ := ar[0]
 	handle := ar[1]
-	if handle[0] == '@' && len(handle) > 1 {
+	if len(handle) > 1 && handle[0] == '@' {
 		handle = handle[1:]
 	}
 	return typ, handle
 }
+
+// resourceSchemes are the URI schemes of the resources that we know how to resolve.
+var resourceSchemes = []string{"acct:", "https://", "http://", didWebPrefix}
+
+// normalizeResource converts the handles and the scheme-less URLs that clients send instead of proper URIs:
+// "@alice@host" and "alice@host" become "acct:alice@host", and "host/@alice" becomes "https://host/@alice".
+// The other values are returned unchanged.
+func normalizeResource(res string) string {
+	res = strings.TrimSpace(res)
+	for _, scheme := range resourceSchemes {
+		if strings.HasPrefix(strings.ToLower(res), scheme) {
+			return res
+		}
+	}
+	switch {
+	case strings.Contains(res, "/"):
+		return "https://" + res
+	case strings.Contains(res, "@"):
+		return "acct:" + strings.TrimPrefix(res, "@")
+	}
+	return res
+}
+
+// AccountURI returns the acct: URI of the actor, with the domain part being the configured account domain,
+// or when that's empty, the host of the actor's IRI.
+func AccountURI(actor vocab.Item, domain string) string {
+	if vocab.IsNil(actor) {
+		return ""
+	}
+	return accountURIOf(vocab.PreferredNameOf(actor), actor.GetLink(), domain)
+}
+
+func accountURIOf(name string, iri vocab.IRI, domain string) string {
+	if name == "" {
+		return ""
+	}
+	if domain == "" {
+		u, err := iri.URL()
+		if err != nil {
+			return ""
+		}
+		domain = u.Host
+	}
+	return "acct:" + name + "@" + domain
+}
+
+// wantsAttributedTo returns true if the request asks for the actor that an object is attributed to,
+// instead of the object itself.
+func wantsAttributedTo(q url.Values) bool {
+	v, _ := strconv.ParseBool(q.Get("attributedTo"))
+	return v
+}
+
+// attributedToOf returns the IRI of the first actor the object is attributed to.
+func attributedToOf(it vocab.Item) vocab.IRI {
+	var author vocab.IRI
+	_ = vocab.OnObject(it, func(ob *vocab.Object) error {
+		if authors := itemsOf(ob.AttributedTo); len(authors) > 0 && !vocab.IsNil(authors[0]) {
+			author = authors[0].GetLink()
+		}
+		return nil
+	})
+	return author
+}
+
+// propertyActorType is the WebFinger property of the self link containing the type of the actor,
+// as used by Lemmy for distinguishing between the groups and the persons with the same name.
+const propertyActorType = "https://www.w3.org/ns/activitystreams#type"
+
+// actorTypeHint returns the actor types that should be preferred when resolving the handle, and the handle
+// without its type prefix. The "!" prefix, used by Lemmy for communities, and the "type" query parameter
+// are hints for Group actors, the query parameter can also contain any of the other actor types.
+func actorTypeHint(handle string, q url.Values) (string, vocab.ActivityVocabularyTypes, error) {
+	types := make(vocab.ActivityVocabularyTypes, 0)
+	if typ := q.Get("type"); typ != "" {
+		for _, valid := range ValidActorTypes {
+			if strings.EqualFold(typ, string(valid)) {
+				types = append(types, valid)
+			}
+		}
+		if len(types) == 0 {
+			return handle, nil, errors.BadRequestf("invalid actor type %s", typ)
+		}
+	}
+	if strings.HasPrefix(handle, "!") && len(handle) > 1 {
+		handle = handle[1:]
+		if len(types) == 0 {
+			types = append(types, vocab.GroupType)
+		}
+	}
+	return handle, types, nil
+}
+
+const (
+	// PublicKeyRel is the link relation for the key in the actor's publicKey property.
+	PublicKeyRel = "https://w3id.org/security#publicKey"
+	// AssertionMethodRel is the link relation for the FEP-521a multikeys of the actor.
+	AssertionMethodRel = "https://w3id.org/security#assertionMethod"
+
+	// NOTE(marius): there are no registered WebFinger properties for public keys, so we use
+	// the IRIs of the corresponding terms from the security vocabulary.
+	propertyKeyType            = "https://w3id.org/security#keyType"
+	propertyPublicKeyPem       = "https://w3id.org/security#publicKeyPem"
+	propertyPublicKeyMultibase = "https://w3id.org/security#publicKeyMultibase"
+)
+
+// keyTypeOf returns the type of the public key: RSA, Ed25519, or the name of the ECDSA curve.
+func keyTypeOf(pub crypto.PublicKey) string {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return "RSA"
+	case ed25519.PublicKey:
+		return "Ed25519"
+	case *ecdsa.PublicKey:
+		return k.Curve.Params().Name
+	}
+	return ""
+}
+
+// publicKeyLinks returns the links to the actor's valid public keys, with the key type and
+// the public key material in their properties.
+func publicKeyLinks(actor vocab.Actor) []link {
+	links := make([]link, 0)
+	if pub, err := publicKeyOf(actor); err == nil {
+		id := actor.PublicKey.ID
+		if id == "" {
+			id = actor.ID
+		}
+		links = append(links, link{
+			Rel:  PublicKeyRel,
+			Type: "application/activity+json",
+			Href: id.String(),
+			Properties: map[string]string{
+				propertyKeyType:      keyTypeOf(pub),
+				propertyPublicKeyPem: actor.PublicKey.PublicKeyPem,
+			},
+		})
+	}
+	for _, k := range multikeysOf(actor, time.Now()) {
+		l := link{
+			Rel:        AssertionMethodRel,
+			Type:       "application/activity+json",
+			Href:       k.ID.String(),
+			Properties: map[string]string{propertyKeyType: keyTypeOf(k.Key)},
+		}
+		if mb, err := multibaseOf(k.Key); err == nil {
+			l.Properties[propertyPublicKeyMultibase] = mb
+		}
+		links = append(links, l)
+	}
+	return links
+}
@@ -0,0 +1,385 @@
+package webfinger
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"git.sr.ht/~mariusor/lw"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+func TestIsServerActorResource(t *testing.T) {
+	root := vocab.Actor{ID: "https://example.com/"}
+	tests := []struct {
+		res  string
+		want bool
+	}{
+		{res: "https://example.com/", want: true},
+		{res: "https://example.com", want: true},
+		{res: "acct:example.com@example.com", want: true},
+		{res: "acct:Example.com@example.com", want: true},
+		{res: "https://example.com/actors/jdoe", want: false},
+		{res: "https://example.org/", want: false},
+		{res: "acct:jdoe@example.com", want: false},
+		{res: "acct:example.org@example.com", want: false},
+	}
+	for _, tt := range tests {
+		typ, handle := splitResourceString(tt.res)
+		user, domain, _ := strings.Cut(handle, "@")
+		if got := isServerActorResource(typ, user, domain, tt.res, root); got != tt.want {
+			t.Errorf("isServerActorResource(%q) = %t, want %t", tt.res, got, tt.want)
+		}
+	}
+}
+
+func TestResolveResource_serverActor(t *testing.T) {
+	root := itemFromJSON(t, `{"id":"https://example.com","type":"Application","preferredUsername":"fedbox"}`)
+	// NOTE(marius): an actor having the host as its name doesn't take the place of the server actor
+	other := itemFromJSON(t, `{
+		"id": "https://example.com/actors/example.com",
+		"type": "Person",
+		"preferredUsername": "example.com",
+		"to": ["https://www.w3.org/ns/activitystreams#Public"]
+	}`)
+	self, _ := vocab.ToActor(root)
+	storage := Storage{Store: newMockStore(root, other), Root: *self}
+
+	for _, res := range []string{"https://example.com/", "https://example.com", "acct:example.com@example.com"} {
+		wf, err := resolveResource(storage, "example.com", res, nil)
+		if err != nil {
+			t.Fatalf("resolveResource(%s) returned error %s", res, err)
+		}
+		if len(wf.Links) == 0 || wf.Links[0].Href != "https://example.com" {
+			t.Errorf("resolveResource(%s) = %+v, expected the root actor's self link", res, wf.Links)
+			continue
+		}
+		if typ := wf.Links[0].Properties[propertyActorType]; typ != string(vocab.ApplicationType) {
+			t.Errorf("resolveResource(%s) actor type %q, expected %s", res, typ, vocab.ApplicationType)
+		}
+	}
+	if wf, _ := resolveResource(storage, "example.com", "acct:example.com@example.com", nil); wf.Subject != "acct:example.com@example.com" {
+		t.Errorf("Invalid subject %s, expected the requested resource", wf.Subject)
+	}
+}
+
+func TestActorTypeHint(t *testing.T) {
+	tests := []struct {
+		handle     string
+		query      url.Values
+		wantHandle string
+		wantTypes  vocab.ActivityVocabularyTypes
+		wantErr    bool
+	}{
+		{handle: "jdoe@example.com", wantHandle: "jdoe@example.com", wantTypes: vocab.ActivityVocabularyTypes{}},
+		{handle: "!community@example.com", wantHandle: "community@example.com", wantTypes: vocab.ActivityVocabularyTypes{vocab.GroupType}},
+		{
+			handle:     "community@example.com",
+			query:      url.Values{"type": {"group"}},
+			wantHandle: "community@example.com",
+			wantTypes:  vocab.ActivityVocabularyTypes{vocab.GroupType},
+		},
+		{
+			handle:     "!bot@example.com",
+			query:      url.Values{"type": {"Service"}},
+			wantHandle: "bot@example.com",
+			wantTypes:  vocab.ActivityVocabularyTypes{vocab.ServiceType},
+		},
+		{handle: "jdoe@example.com", query: url.Values{"type": {"Note"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		handle, types, err := actorTypeHint(tt.handle, tt.query)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("actorTypeHint(%q) returned error %v", tt.handle, err)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if handle != tt.wantHandle || fmt.Sprintf("%v", types) != fmt.Sprintf("%v", tt.wantTypes) {
+			t.Errorf("actorTypeHint(%q) = %q, %v, want %q, %v", tt.handle, handle, types, tt.wantHandle, tt.wantTypes)
+		}
+	}
+}
+
+func TestFirstOfType(t *testing.T) {
+	person := vocab.Actor{ID: "https://example.com/actors/1", Type: vocab.PersonType}
+	group := vocab.Actor{ID: "https://example.com/actors/2", Type: vocab.GroupType}
+	col := vocab.ItemCollection{person, group}
+
+	if got, ok := firstOfType(col, nil); got.GetID() != person.ID || ok {
+		t.Errorf("firstOfType() without types = %s, %t, expected %s, false", got.GetID(), ok, person.ID)
+	}
+	if got, ok := firstOfType(col, vocab.ActivityVocabularyTypes{vocab.GroupType}); got.GetID() != group.ID || !ok {
+		t.Errorf("firstOfType() for Group = %s, %t, expected %s, true", got.GetID(), ok, group.ID)
+	}
+	if got, ok := firstOfType(col, vocab.ActivityVocabularyTypes{vocab.ServiceType}); got.GetID() != person.ID || ok {
+		t.Errorf("firstOfType() for missing type = %s, %t, expected the first item %s, false", got.GetID(), ok, person.ID)
+	}
+}
+
+func TestLoadActorPreferType(t *testing.T) {
+	root := itemFromJSON(t, `{"id":"https://example.com","type":"Service","preferredUsername":"community"}`)
+	person := itemFromJSON(t, `{
+		"id": "https://example.com/actors/community",
+		"type": "Person",
+		"preferredUsername": "community",
+		"to": ["https://www.w3.org/ns/activitystreams#Public"]
+	}`)
+	group := itemFromJSON(t, `{
+		"id": "https://example.com/actors/community-group",
+		"type": "Group",
+		"preferredUsername": "community",
+		"to": ["https://www.w3.org/ns/activitystreams#Public"]
+	}`)
+	groupType := vocab.ActivityVocabularyTypes{vocab.GroupType}
+
+	self, _ := vocab.ToActor(root)
+	storage := Storage{Store: newMockStore(root, person), Root: *self}
+	got, err := LoadActorPreferType(storage, groupType, FilterName("community"))
+	if err != nil {
+		t.Fatalf("LoadActorPreferType() returned error %s", err)
+	}
+	if got.GetLink() != root.GetLink() {
+		t.Errorf("LoadActorPreferType() = %s, expected the root actor %s when no actor has the preferred type", got.GetLink(), root.GetLink())
+	}
+
+	storage.Store = newMockStore(root, person, group)
+	if got, err = LoadActorPreferType(storage, groupType, FilterName("community")); err != nil {
+		t.Fatalf("LoadActorPreferType() returned error %s", err)
+	}
+	if got.GetLink() != group.GetLink() {
+		t.Errorf("LoadActorPreferType() = %s, expected the actor with the preferred type %s", got.GetLink(), group.GetLink())
+	}
+
+	if got, err = LoadActorPreferType(storage, groupType, FilterName("mallory")); !errors.IsNotFound(err) {
+		t.Errorf("LoadActorPreferType() = %v, %v, expected a not found error when no actor matches", got, err)
+	}
+}
+
+func TestAttributedToOf(t *testing.T) {
+	tests := []struct {
+		name string
+		it   vocab.Item
+		want vocab.IRI
+	}{
+		{name: "no author", it: vocab.Object{ID: "https://example.com/objects/1"}},
+		{
+			name: "single author",
+			it:   vocab.Object{ID: "https://example.com/objects/1", AttributedTo: vocab.IRI("https://example.com/actors/jdoe")},
+			want: "https://example.com/actors/jdoe",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := attributedToOf(tt.it); got != tt.want {
+				t.Errorf("attributedToOf() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccountURI(t *testing.T) {
+	if got := AccountURI(vocab.Actor{ID: "https://example.com/actors/jdoe"}, "example.com"); got != "" {
+		t.Errorf("AccountURI() = %q, expected empty value for an actor without a preferred username", got)
+	}
+	if got := AccountURI(nil, "example.com"); got != "" {
+		t.Errorf("AccountURI() = %q, expected empty value for a nil actor", got)
+	}
+	jdoe := itemFromJSON(t, `{"id":"https://example.com/actors/jdoe","type":"Person","preferredUsername":"jdoe"}`)
+	if got := AccountURI(jdoe, ""); got != "acct:jdoe@example.com" {
+		t.Errorf("AccountURI() = %q, expected the host of the actor as domain", got)
+	}
+	if got := AccountURI(jdoe, "example.org"); got != "acct:jdoe@example.org" {
+		t.Errorf("AccountURI() = %q, expected the account domain", got)
+	}
+}
+
+func TestResolveResource_reverse(t *testing.T) {
+	root := itemFromJSON(t, `{"id":"https://example.com","type":"Application"}`)
+	jdoe := itemFromJSON(t, `{
+		"id": "https://example.com/actors/jdoe",
+		"type": "Person",
+		"preferredUsername": "jdoe",
+		"to": ["https://www.w3.org/ns/activitystreams#Public"]
+	}`)
+	note := itemFromJSON(t, `{
+		"id": "https://example.com/objects/1",
+		"type": "Note",
+		"attributedTo": "https://example.com/actors/jdoe",
+		"to": ["https://www.w3.org/ns/activitystreams#Public"]
+	}`)
+	self, _ := vocab.ToActor(root)
+	storage := Storage{
+		Store:   newMockStore(root, jdoe, note),
+		Root:    *self,
+		Options: Options{WebFinger: WebFingerOptions{AccountDomain: "example.org"}},
+	}
+
+	tests := []struct {
+		name        string
+		res         string
+		q           url.Values
+		wantSubject string
+		wantSelf    string
+	}{
+		{
+			name:        "actor",
+			res:         "https://example.com/actors/jdoe",
+			wantSubject: "acct:jdoe@example.org",
+			wantSelf:    "https://example.com/actors/jdoe",
+		},
+		{
+			name:        "object",
+			res:         "https://example.com/objects/1",
+			wantSubject: "https://example.com/objects/1",
+			wantSelf:    "https://example.com/objects/1",
+		},
+		{
+			name:        "object author",
+			res:         "https://example.com/objects/1",
+			q:           url.Values{"attributedTo": {"true"}},
+			wantSubject: "acct:jdoe@example.org",
+			wantSelf:    "https://example.com/actors/jdoe",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wf, err := resolveResource(storage, "example.com", tt.res, tt.q)
+			if err != nil {
+				t.Fatalf("resolveResource(%s) returned error %s", tt.res, err)
+			}
+			if wf.Subject != tt.wantSubject {
+				t.Errorf("Invalid subject %s, expected %s", wf.Subject, tt.wantSubject)
+			}
+			if len(wf.Links) == 0 || wf.Links[0].Href != tt.wantSelf {
+				t.Errorf("Invalid self link %v, expected %s", wf.Links, tt.wantSelf)
+			}
+			if wf.Subject != tt.wantSelf && !slices.Contains(wf.Aliases, tt.wantSelf) {
+				t.Errorf("Invalid aliases %v, expected the IRI %s to be moved to them", wf.Aliases, tt.wantSelf)
+			}
+		})
+	}
+}
+
+func TestNormalizeResource(t *testing.T) {
+	tests := map[string]string{
+		"acct:alice@example.com":          "acct:alice@example.com",
+		"https://example.com/actors/jdoe": "https://example.com/actors/jdoe",
+		"did:web:example.com":             "did:web:example.com",
+		"@alice@example.com":              "acct:alice@example.com",
+		"alice@example.com":               "acct:alice@example.com",
+		" alice@example.com ":             "acct:alice@example.com",
+		"!community@example.com":          "acct:!community@example.com",
+		"example.com/@alice":              "https://example.com/@alice",
+		"example.com":                     "example.com",
+	}
+	for res, want := range tests {
+		if got := normalizeResource(res); got != want {
+			t.Errorf("normalizeResource(%q) = %q, want %q", res, got, want)
+		}
+	}
+}
+
+func TestHandler_HandleWebFinger_normalizedSubject(t *testing.T) {
+	root := itemFromJSON(t, `{"id":"https://example.com","type":"Application"}`)
+	actor := itemFromJSON(t, `{
+		"id": "https://example.com/actors/alice",
+		"type": "Person",
+		"preferredUsername": "alice",
+		"to": ["https://www.w3.org/ns/activitystreams#Public"]
+	}`)
+	note := itemFromJSON(t, `{"id":"https://example.com/objects/1","type":"Note","to":["https://www.w3.org/ns/activitystreams#Public"]}`)
+	db := newMockStore(root, actor, note)
+
+	tests := []struct {
+		res     string
+		strict  bool
+		status  int
+		subject string
+	}{
+		{res: "alice@example.com", status: http.StatusOK, subject: "acct:alice@example.com"},
+		{res: "@alice@example.com", status: http.StatusOK, subject: "acct:alice@example.com"},
+		{res: "example.com/objects/1", status: http.StatusOK, subject: "https://example.com/objects/1"},
+		{res: "alice@example.com", strict: true, status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		h := handler{
+			s: []Store{WithOptions(db, Options{WebFinger: WebFingerOptions{Strict: tt.strict}})},
+			l: lw.Dev(lw.SetLevel(lw.ErrorLevel)),
+		}
+		r := httptest.NewRequest(http.MethodGet, WellKnownWebFingerPath+"?resource="+url.QueryEscape(tt.res), nil)
+		r.Host = "example.com"
+		w := httptest.NewRecorder()
+		h.HandleWebFinger(w, r)
+		if w.Code != tt.status {
+			t.Errorf("HandleWebFinger(%s) strict %t, status %d, expected %d", tt.res, tt.strict, w.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		wf := node{}
+		if err := json.Unmarshal(w.Body.Bytes(), &wf); err != nil {
+			t.Fatalf("unable to unmarshal JRD: %s", err)
+		}
+		if wf.Subject != tt.subject {
+			t.Errorf("HandleWebFinger(%s) subject %s, expected the normalized resource %s", tt.res, wf.Subject, tt.subject)
+		}
+	}
+}
+
+func TestSplitResourceString(t *testing.T) {
+	tests := []struct {
+		res        string
+		wantTyp    string
+		wantHandle string
+	}{
+		{res: "acct:alice@example.com", wantTyp: "acct", wantHandle: "alice@example.com"},
+		{res: "acct:@alice@example.com", wantTyp: "acct", wantHandle: "alice@example.com"},
+		{res: "https://example.com/@alice", wantTyp: "https", wantHandle: "example.com/@alice"},
+		{res: "acct:", wantTyp: "acct", wantHandle: ""},
+		{res: "alice@example.com", wantTyp: "", wantHandle: ""},
+	}
+	for _, tt := range tests {
+		typ, handle := splitResourceString(tt.res)
+		if typ != tt.wantTyp || handle != tt.wantHandle {
+			t.Errorf("splitResourceString(%q) = %q, %q, want %q, %q", tt.res, typ, handle, tt.wantTyp, tt.wantHandle)
+		}
+	}
+}
+
+func TestPublicKeyLinks(t *testing.T) {
+	edPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	keyPem := pemEncodePublicKey(t, edPub)
+	actor := vocab.Actor{
+		ID: "https://example.com/actors/jdoe",
+		PublicKey: vocab.PublicKey{
+			ID:           "https://example.com/actors/jdoe#main",
+			Owner:        "https://example.com/actors/jdoe",
+			PublicKeyPem: keyPem,
+		},
+	}
+	links := publicKeyLinks(actor)
+	if len(links) != 1 {
+		t.Fatalf("Invalid number of links %d, expected 1", len(links))
+	}
+	l := links[0]
+	if l.Rel != PublicKeyRel || l.Href != "https://example.com/actors/jdoe#main" {
+		t.Errorf("Invalid link %+v", l)
+	}
+	if l.Properties[propertyKeyType] != "Ed25519" || l.Properties[propertyPublicKeyPem] != keyPem {
+		t.Errorf("Invalid link properties %v", l.Properties)
+	}
+	if links = publicKeyLinks(vocab.Actor{ID: "https://example.com/actors/jdoe"}); len(links) != 0 {
+		t.Errorf("Invalid links %+v, expected none for an actor without keys", links)
+	}
+}